@@ -1,15 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"potatossh/internal/database"
+	"potatossh/internal/inventory"
+	"potatossh/internal/recording"
 	"potatossh/internal/session"
+	"potatossh/internal/sshconfig"
+	"potatossh/internal/terminal"
 	"potatossh/internal/theme"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
+	"unicode"
 )
 
 type Tab struct {
@@ -23,7 +39,9 @@ type Window struct {
 	Active bool
 }
 
+// App is shared by all HTTP handlers, mu serializes access to its state.
 type App struct {
+	mu               sync.Mutex
 	Db               *database.Database
 	Servers          []*database.ServerOrDir
 	Sessions         map[string]*session.Session
@@ -33,6 +51,7 @@ type App struct {
 	Template         *template.Template
 	Themes           []theme.Theme
 	Settings         database.Settings
+	Broadcast        *session.Broadcast // sessions sharing the keyboard input
 }
 
 func NewApp(dbFile string) *App {
@@ -42,7 +61,7 @@ func NewApp(dbFile string) *App {
 	}
 
 	themes := theme.Load()
-	settings, err := db.GetSettings(database.Settings{Theme: &themes[0], FontSize: 10, OpenInNewWindow: false}, themes)
+	settings, err := db.GetSettings(database.Settings{Theme: &themes[0], FontSize: 10, OpenInNewWindow: false, Bell: database.BELL_VISUAL, Scrollback: terminal.DefaultScrollback, MaxUpload: database.DEFAULT_MAX_UPLOAD}, themes)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -57,11 +76,15 @@ func NewApp(dbFile string) *App {
 		Template:         template.New("index.html"),
 		Themes:           themes,
 		Settings:         settings,
+		Broadcast:        session.NewBroadcast(),
 	}
 	app.Template, err = app.Template.Funcs(template.FuncMap{
 		"openInNewWindowEnabled": func() bool {
 			return app.Settings.OpenInNewWindow
 		},
+		"speeds": func() []float64 {
+			return []float64{0.5, 1, 2, 4, 8, 16}
+		},
 	}).ParseFiles("web/templates/index.html")
 
 	if err != nil {
@@ -71,6 +94,15 @@ func NewApp(dbFile string) *App {
 	return app
 }
 
+// locked runs the handler with the app state locked.
+func (app *App) locked(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		handler(w, r)
+	}
+}
+
 func (app *App) ToMap() map[string]any {
 	return map[string]any{
 		"Servers":  app.Servers,
@@ -106,34 +138,74 @@ func (app *App) ServeHome(w http.ResponseWriter, r *http.Request) {
 	app.Template.Execute(w, app.ToMap())
 }
 
+// ServerRequest shows the server form (GET /server for a new server, GET
+// /server/{id} to edit one), adds (POST), updates (PUT), deletes (DELETE) and
+// clones (POST /server/{id}/clone) servers.
 func (app *App) ServerRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		r.ParseForm()
-		port, err := strconv.ParseUint(r.PostFormValue("port"), 10, 16)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if len(r.PathValue("id")) > 0 && err != nil {
+		http.Error(w, "Can not parse id", http.StatusBadRequest)
+		return
+	}
+	action := r.PathValue("action")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		app.renderServerForm(w, id)
+		return
+	case action == "" && id == 0 && r.Method == http.MethodPost:
+		server, err := app.serverFromForm(r, 0)
 		if err != nil {
-			http.Error(w, "Can not parse port", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		server := database.Server{Name: r.PostFormValue("name"), Address: r.PostFormValue("address"), Port: uint16(port), User: r.PostFormValue("user"), Password: r.PostFormValue("password")}
-		_, err = app.Db.AddServer(&server)
-		if err != nil {
+		if _, err := app.Db.AddServer(&server); err != nil {
 			fmt.Println("Database error:", err)
 			http.Error(w, "Database error", http.StatusBadRequest)
 			return
 		}
 		fmt.Println("new server added")
-	} else if r.Method == http.MethodDelete {
-		id, err := strconv.Atoi(r.PathValue("id"))
+	case action == "" && id != 0 && r.Method == http.MethodPut:
+		existing, err := app.Db.GetServer(id)
 		if err != nil {
-			http.Error(w, "Can not parse id", http.StatusBadRequest)
+			http.Error(w, "Requested server doesn't exist.", http.StatusNotFound)
+			return
+		}
+		server, err := app.serverFromForm(r, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(server.Password) == 0 {
+			server.Password = existing.Password
+		}
+		if err := app.Db.UpdateServer(id, &server); err != nil {
+			fmt.Println("Database error:", err)
+			http.Error(w, "Database error", http.StatusBadRequest)
 			return
 		}
+		fmt.Println("server updated")
+	case action == "" && id != 0 && r.Method == http.MethodDelete:
 		app.Db.DeleteServer(id)
-	} else {
+	case action == "clone" && r.Method == http.MethodPost:
+		server, err := app.Db.GetServer(id)
+		if err != nil {
+			http.Error(w, "Requested server doesn't exist.", http.StatusNotFound)
+			return
+		}
+		clone := server.Server
+		if clone.Name, err = app.freeName(server.Name, nil); err == nil {
+			_, err = app.Db.AddServer(&clone)
+		}
+		if err != nil {
+			fmt.Println("Database error:", err)
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	err := app.UpdateServerList()
+	err = app.UpdateServerList()
 	if err != nil {
 		http.Error(w, "Database error", http.StatusBadRequest)
 		return
@@ -141,7 +213,166 @@ func (app *App) ServerRequest(w http.ResponseWriter, r *http.Request) {
 	app.Template.ExecuteTemplate(w, "server_list", app.Servers)
 }
 
+// serverFromForm reads and checks the server form, id is the edited server or 0.
+func (app *App) serverFromForm(r *http.Request, id int) (database.Server, error) {
+	r.ParseForm()
+	port, err := strconv.ParseUint(r.PostFormValue("port"), 10, 16)
+	if err != nil {
+		return database.Server{}, errors.New("Can not parse port")
+	}
+	server := database.Server{Name: strings.TrimSpace(r.PostFormValue("name")), Address: r.PostFormValue("address"), Port: uint16(port), User: r.PostFormValue("user"), Password: r.PostFormValue("password"), Clipboard: r.PostFormValue("clipboard"), Record: r.PostFormValue("record"), Forwards: strings.TrimSpace(r.PostFormValue("forwards")), IdentityFile: strings.TrimSpace(r.PostFormValue("identity_file")), ProxyJump: strings.TrimSpace(r.PostFormValue("proxy_jump")), Tags: strings.Join(database.Server{Tags: r.PostFormValue("tags")}.TagList(), ", ")}
+	if len(server.Name) == 0 {
+		return server, errors.New("Missing name")
+	}
+	unique, err := app.Db.IsNameUniqueExcept(server.Name, id)
+	if err != nil {
+		return server, errors.New("Database error")
+	}
+	if !unique {
+		return server, errors.New("This name is already used!")
+	}
+	if err := server.CheckConnection(); err != nil {
+		return server, err
+	}
+	if !slices.Contains([]string{"", database.CLIPBOARD_ASK, database.CLIPBOARD_ALLOW, database.CLIPBOARD_DENY}, server.Clipboard) {
+		return server, errors.New("Invalid clipboard setting")
+	}
+	if !slices.Contains([]string{"", database.RECORD_OFF, database.RECORD_OUTPUT, database.RECORD_INPUT}, server.Record) {
+		return server, errors.New("Invalid record setting")
+	}
+	if value := strings.TrimSpace(r.PostFormValue("scrollback")); len(value) > 0 {
+		scrollback, err := strconv.Atoi(value)
+		if err != nil || scrollback < 0 || scrollback > database.MAX_SCROLLBACK {
+			return server, errors.New("Can not parse scrollback")
+		}
+		server.Scrollback = &scrollback
+	}
+	if _, err := session.ParseForwards(server.Forwards); err != nil {
+		return server, err
+	}
+	return server, nil
+}
+
+// renderServerForm fills the server dialog, empty for a new server. The
+// server_form event opens it once swapped.
+func (app *App) renderServerForm(w http.ResponseWriter, id int) {
+	server := database.ServerDbRow{Server: database.Server{Port: 22, Clipboard: database.CLIPBOARD_ASK, Record: database.RECORD_OFF}}
+	if id != 0 {
+		var err error
+		if server, err = app.Db.GetServer(id); err != nil {
+			http.Error(w, "Requested server doesn't exist.", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("HX-Trigger-After-Settle", "server_form")
+	app.Template.ExecuteTemplate(w, "server_form", map[string]any{"Server": server, "Input": server.Name})
+}
+
+// freeName returns the name, or the name followed by a number when it's used
+// by a server or taken.
+func (app *App) freeName(name string, taken map[string]bool) (string, error) {
+	free := name
+	for n := 2; ; n++ {
+		unique, err := app.Db.IsNameUnique(free)
+		if err != nil {
+			return "", err
+		}
+		if unique && !taken[free] {
+			return free, nil
+		}
+		free = fmt.Sprintf("%s (%d)", name, n)
+	}
+}
+
+// audit writes an event of the session to the audit log, remote is the browser which opened it.
+func (app *App) audit(s *session.Session, remote, event, detail string) {
+	entry := database.AuditEntry{
+		Session: s.Id,
+		Server:  s.Server.Name,
+		Address: fmt.Sprintf("%s:%d", s.Server.Address, s.Server.Port),
+		User:    s.Server.User,
+		Event:   event,
+		Auth:    database.AUTH_PASSWORD,
+		Remote:  remote,
+		Detail:  detail,
+	}
+	if len(s.Server.IdentityFile) > 0 {
+		entry.Auth = database.AUTH_PUBLICKEY
+	}
+	if event == database.AUDIT_DISCONNECT {
+		entry.BytesIn, entry.BytesOut = s.Transferred()
+	}
+	if err := app.Db.AddAudit(&entry); err != nil {
+		fmt.Println("Audit error:", err)
+	}
+}
+
+// addTab shows a started session in a new tab of the active window or in a new window.
+func (app *App) addTab(w http.ResponseWriter, server *database.ServerDbRow, session *session.Session, newWindow bool) {
+	if newWindow {
+		if len(app.Windows) > int(app.ActiveWindow) {
+			app.Windows[app.ActiveWindow].Active = false
+		}
+		app.Windows = append(app.Windows, Window{Tabs: []Tab{{Server: server, Session: session, Checked: true}}, Active: true})
+		app.ActiveWindow = uint(len(app.Windows) - 1)
+		app.Template.ExecuteTemplate(w, "new_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
+	} else {
+		if len(app.Windows) == 0 {
+			app.Windows = append(app.Windows, Window{Tabs: []Tab{{Server: server, Session: session, Checked: true}}, Active: true})
+			app.ActiveWindow = 0
+			app.Template.ExecuteTemplate(w, "new_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
+		} else {
+			app.Windows[app.ActiveWindow].Tabs = append(app.Windows[app.ActiveWindow].Tabs, Tab{Server: server, Session: session, Checked: false})
+			app.Template.ExecuteTemplate(w, "update_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
+		}
+	}
+	app.SessionWindowMap[session.Id] = app.ActiveWindow
+	app.Sessions[session.Id] = session
+}
+
+// findTab returns the tab of the session, nil when it's closed.
+func (app *App) findTab(sessionId string) *Tab {
+	for i := range app.Windows {
+		for j := range app.Windows[i].Tabs {
+			if app.Windows[i].Tabs[j].Session.Id == sessionId {
+				return &app.Windows[i].Tabs[j]
+			}
+		}
+	}
+	return nil
+}
+
+// activeTab returns the selected tab of the active window.
+func (app *App) activeTab() *Tab {
+	if int(app.ActiveWindow) >= len(app.Windows) {
+		return nil
+	}
+	for i, tab := range app.Windows[app.ActiveWindow].Tabs {
+		if tab.Checked {
+			return &app.Windows[app.ActiveWindow].Tabs[i]
+		}
+	}
+	return nil
+}
+
 func (app *App) ConnectionRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// the websocket stays attached for the whole session, so the lock is only held for the lookup
+		app.mu.Lock()
+		session, ok := app.Sessions[r.PathValue("id")]
+		app.mu.Unlock()
+		if !ok {
+			http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+			return
+		}
+		err := session.AttachWebSocket(w, r)
+		if err != nil {
+			http.Error(w, "Can not attach to the session.", http.StatusBadRequest)
+		}
+		return
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
 	if r.Method == http.MethodPost {
 		r.ParseForm()
 		serverId, err := strconv.Atoi(r.PathValue("id"))
@@ -159,41 +390,28 @@ func (app *App) ConnectionRequest(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Can not create the session.", http.StatusBadRequest)
 			return
 		}
-		err = session.Start()
-		if err != nil {
-			http.Error(w, "Can not start the session.", http.StatusBadRequest)
-			return
+		session.Terminal().SetTheme(app.Settings.Theme)
+		session.Terminal().SetScrollback(app.Settings.Scrollback)
+		if server.Scrollback != nil {
+			session.Terminal().SetScrollback(*server.Scrollback)
 		}
-		if r.PostFormValue("newwindow") == "true" {
-			if len(app.Windows) > int(app.ActiveWindow) {
-				app.Windows[app.ActiveWindow].Active = false
-			}
-			app.Windows = append(app.Windows, Window{Tabs: []Tab{{Server: &server, Session: session, Checked: true}}, Active: true})
-			app.ActiveWindow = uint(len(app.Windows) - 1)
-			app.Template.ExecuteTemplate(w, "new_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
-		} else {
-			if len(app.Windows) == 0 {
-				app.Windows = append(app.Windows, Window{Tabs: []Tab{{Server: &server, Session: session, Checked: true}}, Active: true})
-				app.ActiveWindow = 0
-				app.Template.ExecuteTemplate(w, "new_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
-			} else {
-				app.Windows[app.ActiveWindow].Tabs = append(app.Windows[app.ActiveWindow].Tabs, Tab{Server: &server, Session: session, Checked: false})
-				app.Template.ExecuteTemplate(w, "update_window", map[string]any{"Windows": app.Windows, "Id": app.ActiveWindow})
+		session.SetBell(app.Settings.Bell)
+		remote := r.RemoteAddr
+		session.SetAudit(func(event, detail string) {
+			app.audit(session, remote, event, detail)
+		}, app.Settings.AuditInput)
+		if server.Record != database.RECORD_OFF {
+			err = session.StartRecording(recording.DIR, server.Record == database.RECORD_INPUT)
+			if err != nil {
+				fmt.Println("Recording error:", err)
 			}
 		}
-		app.SessionWindowMap[session.Id] = app.ActiveWindow
-		app.Sessions[session.Id] = session
-	} else if r.Method == http.MethodGet {
-		sessionId := r.PathValue("id")
-		session, ok := app.Sessions[sessionId]
-		if !ok {
-			http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
-			return
-		}
-		err := session.AttachWebSocket(w, r)
+		err = session.Start()
 		if err != nil {
-			http.Error(w, "Can not attach to the session.", http.StatusBadRequest)
+			http.Error(w, "Can not start the session.", http.StatusBadRequest)
+			return
 		}
+		app.addTab(w, &server, session, r.PostFormValue("newwindow") == "true")
 	} else if r.Method == http.MethodDelete {
 		sessionId := r.PathValue("id")
 		session, ok := app.Sessions[sessionId]
@@ -243,7 +461,8 @@ func (app *App) RemoveWindow(windowIdx uint) {
 func (app *App) ValidateServerName(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	name := r.PostFormValue("name")
-	unique, err := app.Db.IsNameUnique(name)
+	id, _ := strconv.Atoi(r.PostFormValue("id")) // the edited server keeps its name
+	unique, err := app.Db.IsNameUniqueExcept(name, id)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusBadRequest)
 		return
@@ -260,6 +479,237 @@ func (app *App) ValidateServerName(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ImportRow is a server of an imported file, Name is the one it gets: the
+// original one or, when it's already used, a free one.
+type ImportRow struct {
+	database.Server
+	Name     string
+	Conflict bool
+	Error    string
+}
+
+// importRows checks the servers to import against the database and each other.
+func (app *App) importRows(servers []database.Server) ([]ImportRow, error) {
+	rows := []ImportRow{}
+	taken := map[string]bool{}
+	for _, server := range servers {
+		name, err := app.freeName(server.Name, taken)
+		if err != nil {
+			return nil, err
+		}
+		row := ImportRow{Server: server, Name: name, Conflict: name != server.Name}
+		taken[name] = true
+		if err := server.CheckConnection(); err != nil {
+			row.Error = err.Error()
+		} else if _, err := session.ParseForwards(server.Forwards); err != nil {
+			row.Error = err.Error()
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// SshConfig exports the servers as an ssh_config file (GET /sshconfig), shows the
+// servers of an uploaded file or of ~/.ssh/config (POST /sshconfig) and adds
+// the selected ones (POST /sshconfig/import). Passwords are neither exported
+// nor imported.
+func (app *App) SshConfig(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		servers, err := app.Db.ServerList()
+		if err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+		list := []database.Server{}
+		for _, server := range servers {
+			list = append(list, server.Server)
+		}
+		var config bytes.Buffer
+		if err := sshconfig.Write(&config, list); err != nil {
+			http.Error(w, "Can not export the servers: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="ssh_config"`)
+		w.Write(config.Bytes())
+	case action == "" && r.Method == http.MethodPost:
+		var config []byte
+		file, _, err := r.FormFile("file")
+		if err == nil {
+			config, err = io.ReadAll(io.LimitReader(file, 1024*1024))
+			file.Close()
+		} else if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+			config, err = sshConfigFile()
+		}
+		if err != nil {
+			http.Error(w, "Can not read the ssh config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		servers, err := parseSshConfig(string(config))
+		if err != nil {
+			http.Error(w, "Can not parse the ssh config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := app.importRows(servers)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+		app.Template.ExecuteTemplate(w, "import_preview", map[string]any{"Rows": rows, "Config": string(config)})
+	case action == "import" && r.Method == http.MethodPost:
+		r.ParseForm()
+		servers, err := parseSshConfig(r.PostFormValue("config"))
+		if err != nil {
+			http.Error(w, "Can not parse the ssh config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := app.importRows(servers)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+		for _, value := range r.PostForm["import"] {
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 0 || i >= len(rows) || len(rows[i].Error) > 0 {
+				continue
+			}
+			server := rows[i].Server
+			server.Name = rows[i].Name
+			if _, err := app.Db.AddServer(&server); err != nil {
+				fmt.Println("Database error:", err)
+				http.Error(w, "Database error", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := app.UpdateServerList(); err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+		app.Template.ExecuteTemplate(w, "server_list", app.Servers)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Inventory exports the server tree as JSON or CSV (/inventory/export?format=),
+// with the passwords encrypted when secrets=on and a passphrase is given, and
+// imports such a file (POST /inventory/import, multipart or the raw file).
+// Servers are matched by name: re-importing updates them instead of adding copies.
+func (app *App) Inventory(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.PathValue("action") == "export" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		r.ParseForm()
+		var secrets *inventory.Secrets
+		if r.Form.Get("secrets") == "on" {
+			if len(r.Form.Get("passphrase")) == 0 {
+				http.Error(w, "A passphrase is needed to export the secrets.", http.StatusBadRequest)
+				return
+			}
+			secrets = inventory.NewSecrets(r.Form.Get("passphrase"))
+		}
+		if err := app.UpdateServerList(); err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+		items, err := inventory.Export(app.Servers, secrets)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		switch r.Form.Get("format") {
+		case inventory.FORMAT_CSV:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="servers.csv"`)
+			inventory.WriteCSV(w, items)
+		case inventory.FORMAT_JSON, "":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", `attachment; filename="servers.json"`)
+			inventory.WriteJSON(w, items)
+		default:
+			http.Error(w, "Unknown format", http.StatusBadRequest)
+		}
+	case r.PathValue("action") == "import" && r.Method == http.MethodPost:
+		var items []inventory.Item
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			file, _, fileErr := r.FormFile("file")
+			if fileErr != nil {
+				app.Template.ExecuteTemplate(w, "inventory_result", map[string]any{"Error": "Choose a file to import."})
+				return
+			}
+			items, err = inventory.Read(file)
+			file.Close()
+		} else {
+			items, err = inventory.Read(http.MaxBytesReader(w, r.Body, 10*1024*1024))
+		}
+		if err == nil {
+			var added, updated int
+			added, updated, err = app.importInventory(items, r.FormValue("passphrase"))
+			if err == nil {
+				err = app.UpdateServerList()
+			}
+			if err == nil {
+				app.Template.ExecuteTemplate(w, "inventory_result", map[string]any{"Added": added, "Updated": updated, "Servers": app.Servers})
+				return
+			}
+		}
+		app.Template.ExecuteTemplate(w, "inventory_result", map[string]any{"Error": err.Error()})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// importInventory checks all the items before saving any of them.
+func (app *App) importInventory(items []inventory.Item, passphrase string) (int, int, error) {
+	var secrets *inventory.Secrets
+	if len(passphrase) > 0 {
+		secrets = inventory.NewSecrets(passphrase)
+	}
+	servers := []database.Server{}
+	names := map[string]bool{}
+	for _, item := range items {
+		server, err := item.Server(secrets)
+		if err != nil {
+			return 0, 0, err
+		}
+		if names[server.Name] {
+			return 0, 0, fmt.Errorf("%s is listed twice", server.Name)
+		}
+		names[server.Name] = true
+		servers = append(servers, server)
+	}
+	added, updated, err := app.Db.UpsertServers(servers)
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return 0, 0, errors.New("Database error")
+	}
+	return added, updated, nil
+}
+
+// sshConfigFile reads ~/.ssh/config.
+func sshConfigFile() ([]byte, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(home, ".ssh", "config"))
+}
+
+// parseSshConfig returns the servers of the config, its Include paths are relative to ~/.ssh.
+func parseSshConfig(config string) ([]database.Server, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	c, err := sshconfig.Parse(strings.NewReader(config), filepath.Join(home, ".ssh"))
+	if err != nil {
+		return nil, err
+	}
+	return c.Servers(), nil
+}
+
 func (app *App) SetActiveTab(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		sessionId := r.PathValue("id")
@@ -395,31 +845,73 @@ func (app *App) ThemePreview(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ApplySettings validates all fields of the settings form before changing any of them.
 func (app *App) ApplySettings(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		r.ParseForm()
 		font_size, err := strconv.Atoi(r.PostFormValue("font_size"))
-		if err != nil {
+		if err != nil || font_size <= 0 {
 			http.Error(w, "Can not parse font_size", http.StatusBadRequest)
 			return
 		}
-
-		fontsize_update := (app.Settings.FontSize != uint(font_size))
-		app.Settings.FontSize = uint(font_size)
-
 		theme_id, err := strconv.Atoi(r.PostFormValue("theme"))
-		if err != nil {
+		if err != nil || theme_id < 0 || theme_id >= len(app.Themes) {
 			http.Error(w, "Can not parse theme id", http.StatusBadRequest)
 			return
 		}
+		bell := r.PostFormValue("bell")
+		if !slices.Contains([]string{database.BELL_NONE, database.BELL_VISUAL, database.BELL_SOUND, database.BELL_NOTIFICATION}, bell) {
+			http.Error(w, "Unknown bell mode", http.StatusBadRequest)
+			return
+		}
+		scrollback, err := strconv.Atoi(r.PostFormValue("scrollback"))
+		if err != nil || scrollback < 0 || scrollback > database.MAX_SCROLLBACK {
+			http.Error(w, "Can not parse scrollback", http.StatusBadRequest)
+			return
+		}
+		max_upload, err := strconv.Atoi(r.PostFormValue("max_upload"))
+		if err != nil || max_upload < 0 {
+			http.Error(w, "Can not parse max_upload", http.StatusBadRequest)
+			return
+		}
+		val, ok := r.PostForm["new_behavior"]
+		openInNewWindow := ok && val[0] == "on"
+		val, ok = r.PostForm["audit_input"]
+		auditInput := ok && val[0] == "on"
+
+		fontsize_update := (app.Settings.FontSize != uint(font_size))
+		app.Settings.FontSize = uint(font_size)
+
 		theme_update := (app.Settings.Theme != &app.Themes[theme_id])
 		app.Settings.Theme = &app.Themes[theme_id]
+		if theme_update {
+			for _, session := range app.Sessions {
+				session.Terminal().SetTheme(app.Settings.Theme)
+			}
+		}
 
-		val, ok := r.PostForm["new_behavior"]
-		openInNewWindow := ok && val[0] == "on"
 		newbehavior_update := openInNewWindow != app.Settings.OpenInNewWindow
 		app.Settings.OpenInNewWindow = openInNewWindow
 
+		app.Settings.Bell = bell
+		for _, session := range app.Sessions {
+			session.SetBell(bell)
+		}
+
+		app.Settings.Scrollback = scrollback
+		for _, session := range app.Sessions {
+			if session.Server.Scrollback == nil {
+				session.Terminal().SetScrollback(scrollback)
+			}
+		}
+
+		app.Settings.MaxUpload = max_upload
+
+		app.Settings.AuditInput = auditInput
+		for _, session := range app.Sessions {
+			session.SetAuditInput(app.Settings.AuditInput)
+		}
+
 		app.Template.ExecuteTemplate(w, "settings_form", app.ToMap())
 		if theme_update {
 			app.Template.ExecuteTemplate(w, "theme_oob", app.Settings.Theme)
@@ -461,23 +953,703 @@ func (app *App) SetTitle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// BroadcastInput toggles the sharing of the keyboard input: POST /broadcast
+// adds all the tabs to the group, or empties it, and POST /broadcast/{sessionid}
+// includes or excludes one tab. The changed tab titles are swapped out of band.
+func (app *App) BroadcastInput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	changed := []*session.Session{}
+	if sessionId := r.PathValue("sessionid"); len(sessionId) > 0 {
+		session, ok := app.Sessions[sessionId]
+		if !ok || session.Player() != nil {
+			http.Error(w, "Requested session doesn't exist.", http.StatusBadRequest)
+			return
+		}
+		app.Broadcast.Toggle(session)
+		changed = append(changed, session)
+	} else if app.Broadcast.Len() > 0 {
+		changed = app.Broadcast.Clear()
+	} else {
+		for _, session := range app.Sessions {
+			if session.Player() == nil {
+				app.Broadcast.Add(session)
+				changed = append(changed, session)
+			}
+		}
+	}
+	for _, session := range changed {
+		app.Template.ExecuteTemplate(w, "title_oob", session)
+	}
+}
+
+func (app *App) LastOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		sessionId := r.PathValue("sessionid")
+		session, ok := app.Sessions[sessionId]
+		if !ok {
+			http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+			return
+		}
+		output, ok := session.Terminal().LastCommandOutput()
+		if !ok {
+			http.Error(w, "No finished command found (shell integration required).", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(output))
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *App) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		sessionId := r.PathValue("sessionid")
+		session, ok := app.Sessions[sessionId]
+		if !ok {
+			http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query()
+		result, err := session.Terminal().Search(terminal.SearchQuery{
+			Pattern:       query.Get("q"),
+			Regex:         query.Get("regex") == "on",
+			CaseSensitive: query.Get("case") == "on",
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *App) Record(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		sessionId := r.PathValue("sessionid")
+		session, ok := app.Sessions[sessionId]
+		if !ok {
+			http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+			return
+		}
+		if session.Recording() {
+			session.StopRecording()
+		} else {
+			err := session.StartRecording(recording.DIR, session.Server.Record == database.RECORD_INPUT)
+			if err != nil {
+				fmt.Println("Recording error:", err)
+				http.Error(w, "Can not start the recording.", http.StatusInternalServerError)
+				return
+			}
+		}
+		app.Template.ExecuteTemplate(w, "record_button", session)
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Recordings lists the recordings (GET /recordings) or downloads one (GET
+// /recordings/{name}). It locks the app state itself, not while downloading.
+func (app *App) Recordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+	if len(name) == 0 {
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		recordings, err := recording.List(recording.DIR)
+		if err != nil {
+			http.Error(w, "Can not list the recordings.", http.StatusInternalServerError)
+			return
+		}
+		app.Template.ExecuteTemplate(w, "recordings_list", recordings)
+		return
+	}
+	app.mu.Lock()
+	path, ok := recording.Path(recording.DIR, name)
+	app.mu.Unlock()
+	if !ok {
+		http.Error(w, "Invalid recording name.", http.StatusBadRequest)
+		return
+	}
+	// a download can be slow, it doesn't hold the app state
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
+
+// Playback opens a recording in a new tab (POST /playback) or controls the
+// player of a playback session (POST /playback/{sessionid}).
+func (app *App) Playback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	sessionId := r.PathValue("sessionid")
+	if len(sessionId) == 0 {
+		playback, err := session.NewPlayback(r.PostFormValue("name"), app.Template)
+		if err != nil {
+			fmt.Println("Playback error:", err)
+			http.Error(w, "Can not open the recording.", http.StatusBadRequest)
+			return
+		}
+		playback.Terminal().SetTheme(app.Settings.Theme)
+		playback.Start()
+		app.addTab(w, &database.ServerDbRow{Server: playback.Server}, playback, r.PostFormValue("newwindow") == "true")
+		return
+	}
+
+	playback, ok := app.Sessions[sessionId]
+	if !ok || playback.Player() == nil {
+		http.Error(w, "Requested playback doesn't exist.", http.StatusNotFound)
+		return
+	}
+	player := playback.Player()
+	switch r.PostFormValue("action") {
+	case "play":
+		player.SetPaused(false)
+	case "pause":
+		player.SetPaused(true)
+	case "seek":
+		position, err := strconv.ParseFloat(r.PostFormValue("position"), 64)
+		if err != nil {
+			http.Error(w, "Can not parse position", http.StatusBadRequest)
+			return
+		}
+		playback.Seek(position)
+	case "speed":
+		speed, err := strconv.ParseFloat(r.PostFormValue("speed"), 64)
+		if err != nil || speed <= 0 {
+			http.Error(w, "Can not parse speed", http.StatusBadRequest)
+			return
+		}
+		player.SetSpeed(speed)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	app.Template.ExecuteTemplate(w, "playback_controls", playback)
+}
+
+// Audit shows the filtered audit log, or exports it with format=csv or format=json.
+func (app *App) Audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter := database.AuditFilter{Server: query.Get("server"), Event: query.Get("event"), Session: query.Get("session")}
+	if since := query.Get("since"); len(since) > 0 {
+		t, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			http.Error(w, "Can not parse since", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); len(until) > 0 {
+		t, err := time.ParseInLocation("2006-01-02", until, time.Local)
+		if err != nil {
+			http.Error(w, "Can not parse until", http.StatusBadRequest)
+			return
+		}
+		filter.Until = t.AddDate(0, 0, 1) // including the day
+	}
+	format := query.Get("format")
+	if format == "csv" || format == "json" {
+		filter.Limit = -1
+	}
+	entries, err := app.Db.AuditList(filter)
+	if err != nil {
+		fmt.Println("Database error:", err)
+		http.Error(w, "Database error", http.StatusBadRequest)
+		return
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "session", "server", "address", "user", "event", "auth", "remote", "bytes_in", "bytes_out", "detail"})
+		for _, e := range entries {
+			writer.Write([]string{e.Time.Format(time.RFC3339), e.Session, e.Server, e.Address, e.User, e.Event, e.Auth, e.Remote, strconv.FormatInt(e.BytesIn, 10), strconv.FormatInt(e.BytesOut, 10), e.Detail})
+		}
+		writer.Flush()
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		json.NewEncoder(w).Encode(entries)
+	default:
+		app.Template.ExecuteTemplate(w, "audit_list", entries)
+	}
+}
+
+// Files serves the SFTP file browser of a session: GET lists ?path= (the shell's
+// directory by default), DELETE removes it and the actions are stat, exists,
+// download, upload (resolving a conflict with ?conflict= like the drop upload),
+// rename and mkdir. The app lock is only held for the session lookup,
+// so transfers don't block the other requests.
+func (app *App) Files(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	s, ok := app.Sessions[r.PathValue("sessionid")]
+	app.mu.Unlock()
+	if !ok {
+		http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+		return
+	}
+	p, err := s.ResolvePath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := r.PathValue("action")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		app.renderFiles(w, s, p, nil)
+	case action == "" && r.Method == http.MethodDelete:
+		app.renderFiles(w, s, path.Dir(p), s.Delete(p))
+	case action == "stat" && r.Method == http.MethodGet:
+		info, err := s.Stat(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	case action == "download" && r.Method == http.MethodGet:
+		started := false
+		err := s.Download(p, w, func(size int64) {
+			started = true
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(p)))
+		})
+		if err != nil && !started {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			fmt.Println("Download error:", err)
+		}
+	case action == "exists" && r.Method == http.MethodGet:
+		names := r.URL.Query()["name"]
+		for _, name := range names {
+			if !validFileName(name) {
+				http.Error(w, "Invalid name.", http.StatusBadRequest)
+				return
+			}
+		}
+		existing, err := s.Existing(p, names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(existing)
+	case action == "upload" && r.Method == http.MethodPost:
+		conflict := r.URL.Query().Get("conflict")
+		if !slices.Contains([]string{session.CONFLICT_OVERWRITE, session.CONFLICT_RENAME, session.CONFLICT_SKIP}, conflict) {
+			conflict = session.CONFLICT_RENAME
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Can not parse upload", http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			name := path.Base(part.FileName())
+			if !validFileName(name) {
+				continue
+			}
+			if _, err := s.UploadFile(p, name, conflict, part); err != nil && !errors.Is(err, session.ErrFileExists) {
+				// also when the browser canceled the upload
+				fmt.Println("Upload error:", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		app.renderFiles(w, s, p, nil)
+	case (action == "rename" || action == "mkdir") && r.Method == http.MethodPost:
+		name, ok := r.Header["Hx-Prompt"]
+		if !ok || !validFileName(name[0]) {
+			http.Error(w, "Invalid name.", http.StatusBadRequest)
+			return
+		}
+		if action == "rename" {
+			app.renderFiles(w, s, path.Dir(p), s.Rename(p, path.Join(path.Dir(p), name[0])))
+		} else {
+			app.renderFiles(w, s, p, s.Mkdir(path.Join(p, name[0])))
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validFileName rejects paths and control characters, a new line would end the scp record.
+func validFileName(name string) bool {
+	return len(name) > 0 && name != "." && name != ".." && !strings.Contains(name, "/") && !strings.ContainsFunc(name, unicode.IsControl)
+}
+
+// DropUpload writes files dropped onto a tab to the remote working directory.
+// GET ?name=... returns the directory, the names which already exist and the
+// size limit in bytes. POST ?id=&name=&size=&conflict= streams the body, the
+// progress is reported as upload events on the websocket.
+func (app *App) DropUpload(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	s, ok := app.Sessions[r.PathValue("sessionid")]
+	limit := int64(app.Settings.MaxUpload) * 1024 * 1024
+	app.mu.Unlock()
+	if !ok || s.Player() != nil {
+		http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	dir := s.UploadDir()
+	if r.Method == http.MethodGet {
+		names := query["name"]
+		for _, name := range names {
+			if !validFileName(name) {
+				http.Error(w, "Invalid name.", http.StatusBadRequest)
+				return
+			}
+		}
+		existing, err := s.Existing(dir, names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"dir": dir, "existing": existing, "limit": limit})
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := query.Get("name")
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if !validFileName(name) || err != nil || size < 0 {
+		http.Error(w, "Invalid name or size.", http.StatusBadRequest)
+		return
+	}
+	if limit > 0 && size > limit {
+		http.Error(w, fmt.Sprintf("The file is larger than the limit of %d MiB.", limit/1024/1024), http.StatusRequestEntityTooLarge)
+		return
+	}
+	conflict := query.Get("conflict")
+	if !slices.Contains([]string{session.CONFLICT_OVERWRITE, session.CONFLICT_RENAME, session.CONFLICT_SKIP}, conflict) {
+		conflict = session.CONFLICT_RENAME
+	}
+	p, err := s.DropFile(query.Get("id"), dir, name, size, conflict, http.MaxBytesReader(w, r.Body, size))
+	if err != nil {
+		fmt.Println("Upload error:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(p))
+}
+
+// Forwards serves the port forwardings panel of a session: GET lists them with
+// their connection counts, POST starts the "spec" form value and DELETE stops
+// ?forward=id. Remote forwardings wait for the server, so the app lock is only
+// held for the session lookup.
+func (app *App) Forwards(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	s, ok := app.Sessions[r.PathValue("sessionid")]
+	app.mu.Unlock()
+	if !ok || s.Player() != nil {
+		http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		r.ParseForm()
+		forward, parseErr := session.ParseForward(r.PostFormValue("spec"))
+		if err = parseErr; err == nil {
+			err = s.AddForward(forward)
+		}
+	case http.MethodDelete:
+		id, parseErr := strconv.Atoi(r.URL.Query().Get("forward"))
+		if parseErr != nil || !s.StopForward(id) {
+			http.Error(w, "Requested forwarding doesn't exist.", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data := map[string]any{"Session": s, "Forwards": s.Forwards()}
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	app.Template.ExecuteTemplate(w, "forwards_panel", data)
+}
+
+// Exec runs one-off commands on a session's connection, without a PTY: GET
+// shows the panel with ?snippet= selected, params renders the inputs of the
+// {{name}} parameters of the command, run executes it, save stores it as a
+// snippet named by the prompt and DELETE removes ?snippet=. Commands can run
+// for a while, so the app lock is only held for the session lookup.
+func (app *App) Exec(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	s, ok := app.Sessions[r.PathValue("sessionid")]
+	filter := database.SnippetFilter{}
+	if tab := app.findTab(r.PathValue("sessionid")); tab != nil {
+		filter.Server = tab.Server.ID
+	}
+	app.mu.Unlock()
+	if !ok || s.Player() != nil {
+		http.Error(w, "Requested session doesn't exist.", http.StatusNotFound)
+		return
+	}
+	r.ParseForm()
+	command := r.Form.Get("command")
+	values := map[string]string{}
+	for _, param := range database.SnippetParams(command) {
+		values[param] = r.Form.Get("param_" + param)
+	}
+	data := map[string]any{"Session": s, "SnippetId": 0, "Command": command, "Params": database.SnippetParams(command), "Values": values}
+	var err error
+	action := r.PathValue("action")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		if id, parseErr := strconv.Atoi(r.Form.Get("snippet")); parseErr == nil {
+			snippet, dbErr := app.Db.GetSnippet(id)
+			if err = dbErr; err == nil {
+				data["SnippetId"], data["Command"], data["Params"] = snippet.ID, snippet.Body, snippet.Params()
+			}
+		}
+	case action == "params" && r.Method == http.MethodGet:
+		app.Template.ExecuteTemplate(w, "exec_params", data)
+		return
+	case action == "run" && r.Method == http.MethodPost:
+		if len(strings.TrimSpace(command)) == 0 {
+			http.Error(w, "Empty command.", http.StatusBadRequest)
+			return
+		}
+		result := s.Exec(database.ExpandSnippet(command, values), session.EXEC_TIMEOUT)
+		data["Result"] = &result
+	case action == "save" && r.Method == http.MethodPost:
+		name, ok := r.Header["Hx-Prompt"]
+		if !ok || len(strings.TrimSpace(name[0])) == 0 || len(strings.TrimSpace(command)) == 0 {
+			http.Error(w, "Invalid name or command.", http.StatusBadRequest)
+			return
+		}
+		id, dbErr := app.Db.AddSnippet(&database.Snippet{Name: strings.TrimSpace(name[0]), Body: command})
+		if err = dbErr; err == nil {
+			data["SnippetId"] = int(id)
+		}
+	case action == "" && r.Method == http.MethodDelete:
+		id, parseErr := strconv.Atoi(r.Form.Get("snippet"))
+		if parseErr != nil {
+			http.Error(w, "Can not parse id", http.StatusBadRequest)
+			return
+		}
+		err = app.Db.DeleteSnippet(id)
+		data["Command"], data["Params"] = "", []string{}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snippets, listErr := app.Db.SnippetList(filter)
+	if err == nil {
+		err = listErr
+	}
+	data["Snippets"] = snippets
+	if err != nil {
+		fmt.Println("Database error:", err)
+		data["Error"] = err.Error()
+	}
+	app.Template.ExecuteTemplate(w, "exec_panel", data)
+}
+
+// Snippets manages the snippet library: GET /snippets lists the snippets usable
+// in the active tab (all of them with ?all=on) filtered by ?q= and ?tag=, POST
+// adds one, GET /snippets/{id} returns its form (0 for a new one), POST updates
+// it and DELETE removes it. POST /snippets/{id}/insert pastes the snippet into
+// the active tab, the {{name}} parameters are asked first.
+func (app *App) Snippets(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if len(r.PathValue("id")) > 0 && err != nil {
+		http.Error(w, "Can not parse id", http.StatusBadRequest)
+		return
+	}
+	action := r.PathValue("action")
+	switch {
+	case r.PathValue("id") == "" && r.Method == http.MethodGet:
+	case r.PathValue("id") == "" && r.Method == http.MethodPost, action == "" && r.Method == http.MethodPost:
+		serverId, _ := strconv.Atoi(r.PostFormValue("server"))
+		snippet := database.Snippet{
+			Name:     strings.TrimSpace(r.PostFormValue("name")),
+			Body:     r.PostFormValue("body"),
+			Tags:     strings.Join(database.Snippet{Tags: r.PostFormValue("tags")}.TagList(), ", "),
+			ServerID: serverId,
+		}
+		if len(snippet.Name) == 0 || len(snippet.Body) == 0 {
+			http.Error(w, "Missing name or body.", http.StatusBadRequest)
+			return
+		}
+		if id == 0 {
+			_, err = app.Db.AddSnippet(&snippet)
+		} else {
+			err = app.Db.UpdateSnippet(id, &snippet)
+		}
+		if err != nil {
+			fmt.Println("Database error:", err)
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+	case action == "" && r.Method == http.MethodGet:
+		app.renderSnippetForm(w, id)
+		return
+	case action == "" && r.Method == http.MethodDelete:
+		if err := app.Db.DeleteSnippet(id); err != nil {
+			http.Error(w, "Database error", http.StatusBadRequest)
+			return
+		}
+	case action == "insert" && r.Method == http.MethodPost:
+		app.insertSnippet(w, r, id)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter := database.SnippetFilter{Text: r.Form.Get("q"), Tag: strings.TrimSpace(r.Form.Get("tag"))}
+	if tab := app.activeTab(); tab != nil && r.Form.Get("all") != "on" {
+		filter.Server = tab.Server.ID
+	}
+	snippets, err := app.Db.SnippetList(filter)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusBadRequest)
+		return
+	}
+	servers, err := app.Db.ServerList()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusBadRequest)
+		return
+	}
+	names := map[int]string{}
+	for _, server := range servers {
+		names[server.ID] = server.Name
+	}
+	app.Template.ExecuteTemplate(w, "snippets_list", map[string]any{"Snippets": snippets, "ServerNames": names})
+}
+
+func (app *App) renderSnippetForm(w http.ResponseWriter, id int) {
+	snippet := database.SnippetDbRow{}
+	if id != 0 {
+		var err error
+		if snippet, err = app.Db.GetSnippet(id); err != nil {
+			http.Error(w, "Requested snippet doesn't exist.", http.StatusNotFound)
+			return
+		}
+	}
+	servers, err := app.Db.ServerList()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusBadRequest)
+		return
+	}
+	app.Template.ExecuteTemplate(w, "snippet_form", map[string]any{"Snippet": snippet, "ServerList": servers})
+}
+
+// insertSnippet pastes the snippet into the active tab. Without a value for
+// each parameter, the form asking them is returned instead.
+func (app *App) insertSnippet(w http.ResponseWriter, r *http.Request, id int) {
+	snippet, err := app.Db.GetSnippet(id)
+	if err != nil {
+		http.Error(w, "Requested snippet doesn't exist.", http.StatusNotFound)
+		return
+	}
+	tab := app.activeTab()
+	if tab == nil || tab.Session.Player() != nil {
+		http.Error(w, "No active session.", http.StatusBadRequest)
+		return
+	}
+	params := snippet.Params()
+	values := map[string]string{}
+	for _, param := range params {
+		if _, ok := r.PostForm["param_"+param]; !ok {
+			app.Template.ExecuteTemplate(w, "snippet_params", map[string]any{"Snippet": snippet, "Params": params, "Title": tab.Session.Terminal().Title()})
+			return
+		}
+		values[param] = r.PostFormValue("param_" + param)
+	}
+	if err := tab.Session.Paste(database.ExpandSnippet(snippet.Body, values)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("HX-Trigger", "snippet_inserted")
+}
+
+// renderFiles shows the directory in the file browser, with the error of the last action.
+func (app *App) renderFiles(w http.ResponseWriter, s *session.Session, dir string, err error) {
+	files, listErr := s.ListDir(dir)
+	if err == nil {
+		err = listErr
+	}
+	data := map[string]any{"Session": s, "Dir": dir, "Parent": path.Dir(dir), "Files": files}
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	app.Template.ExecuteTemplate(w, "file_browser", data)
+}
+
 func main() {
 	app := NewApp("potato.sqlite")
-	http.HandleFunc("/", app.ServeHome)
+	http.HandleFunc("/", app.locked(app.ServeHome))
 	http.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "web"+r.URL.Path)
 	})
-	http.HandleFunc("/server", app.ServerRequest)
-	http.HandleFunc("/server/{id}", app.ServerRequest)
-	http.HandleFunc("/validate/name", app.ValidateServerName)
+	http.HandleFunc("/server", app.locked(app.ServerRequest))
+	http.HandleFunc("/server/{id}", app.locked(app.ServerRequest))
+	http.HandleFunc("/server/{id}/{action}", app.locked(app.ServerRequest))
+	http.HandleFunc("/validate/name", app.locked(app.ValidateServerName))
+	http.HandleFunc("/sshconfig", app.locked(app.SshConfig))
+	http.HandleFunc("/sshconfig/{action}", app.locked(app.SshConfig))
+	http.HandleFunc("/inventory/{action}", app.locked(app.Inventory))
 	http.HandleFunc("/connection/{id}", app.ConnectionRequest)
-	http.HandleFunc("/active/tab/{id}", app.SetActiveTab)
-	http.HandleFunc("/active/window/{id}", app.SetActiveWindow)
-	http.HandleFunc("/move/{action}/{sessionid}", app.MoveTab)
-	http.HandleFunc("/move/window/{sessionid}/{windowid}", app.SwitchWindow)
-	http.HandleFunc("/title/{sessionid}", app.SetTitle)
-	http.HandleFunc("/preview", app.ThemePreview)
-	http.HandleFunc("/settings", app.ApplySettings)
+	http.HandleFunc("/active/tab/{id}", app.locked(app.SetActiveTab))
+	http.HandleFunc("/active/window/{id}", app.locked(app.SetActiveWindow))
+	http.HandleFunc("/move/{action}/{sessionid}", app.locked(app.MoveTab))
+	http.HandleFunc("/move/window/{sessionid}/{windowid}", app.locked(app.SwitchWindow))
+	http.HandleFunc("/title/{sessionid}", app.locked(app.SetTitle))
+	http.HandleFunc("/snippets", app.locked(app.Snippets))
+	http.HandleFunc("/snippets/{id}", app.locked(app.Snippets))
+	http.HandleFunc("/snippets/{id}/{action}", app.locked(app.Snippets))
+	http.HandleFunc("/broadcast", app.locked(app.BroadcastInput))
+	http.HandleFunc("/broadcast/{sessionid}", app.locked(app.BroadcastInput))
+	http.HandleFunc("/output/{sessionid}", app.locked(app.LastOutput))
+	http.HandleFunc("/search/{sessionid}", app.locked(app.Search))
+	http.HandleFunc("/record/{sessionid}", app.locked(app.Record))
+	http.HandleFunc("/recordings", app.Recordings)
+	http.HandleFunc("/recordings/{name}", app.Recordings)
+	http.HandleFunc("/playback", app.locked(app.Playback))
+	http.HandleFunc("/playback/{sessionid}", app.locked(app.Playback))
+	http.HandleFunc("/audit", app.locked(app.Audit))
+	http.HandleFunc("/files/{sessionid}", app.Files)
+	http.HandleFunc("/files/{sessionid}/{action}", app.Files)
+	http.HandleFunc("/upload/{sessionid}", app.DropUpload)
+	http.HandleFunc("/forwards/{sessionid}", app.Forwards)
+	http.HandleFunc("/exec/{sessionid}", app.Exec)
+	http.HandleFunc("/exec/{sessionid}/{action}", app.Exec)
+	http.HandleFunc("/preview", app.locked(app.ThemePreview))
+	http.HandleFunc("/settings", app.locked(app.ApplySettings))
 
 	log.Fatal(http.ListenAndServe("localhost:8080", nil))
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"potatossh/internal/database"
+	"potatossh/internal/theme"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func newTestApp(t *testing.T) *App {
+	db, err := database.Open(filepath.Join(t.TempDir(), "potato.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &App{Db: db, Template: template.Must(template.New("test").Parse(`{{ define "server_list" }}{{ end }}`))}
+}
+
+// serverRequest sends the server form to ServerRequest and returns the status.
+func serverRequest(app *App, method, path string, form url.Values) int {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/server/{id}", app.ServerRequest)
+	mux.HandleFunc("/server/{id}/{action}", app.ServerRequest)
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestEditServer(t *testing.T) {
+	app := newTestApp(t)
+	id, _ := app.Db.AddServer(&database.Server{Name: "web", Address: "10.0.0.1", Port: 22, User: "root", Password: "secret"})
+	app.Db.AddServer(&database.Server{Name: "db", Address: "10.0.0.2", Port: 22, User: "root"})
+	path := "/server/" + strconv.FormatInt(id, 10)
+
+	form := url.Values{"name": {"web"}, "address": {"10.0.0.3"}, "port": {"2222"}, "user": {"admin"}, "password": {""}, "clipboard": {"ask"}, "record": {"off"}}
+	if code := serverRequest(app, http.MethodPut, path, form); code != http.StatusOK {
+		t.Fatalf("Update keeping the name: %d", code)
+	}
+	server, err := app.Db.GetServer(int(id))
+	if err != nil || server.Address != "10.0.0.3" || server.Port != 2222 || server.User != "admin" {
+		t.Errorf("Updated server: %+v %v", server, err)
+	}
+	if server.Password != "secret" {
+		t.Errorf("Empty password replaced the stored one: %q", server.Password)
+	}
+	if server.Scrollback != nil {
+		t.Errorf("Scrollback without override: %d", *server.Scrollback)
+	}
+
+	form.Set("scrollback", "5000")
+	serverRequest(app, http.MethodPut, path, form)
+	if server, _ := app.Db.GetServer(int(id)); server.Scrollback == nil || *server.Scrollback != 5000 {
+		t.Errorf("Scrollback override: %v", server.Scrollback)
+	}
+
+	form.Set("name", "db")
+	if code := serverRequest(app, http.MethodPut, path, form); code != http.StatusBadRequest {
+		t.Errorf("Rename to a used name: %d", code)
+	}
+	form.Set("name", "web")
+	form.Set("clipboard", "always")
+	if code := serverRequest(app, http.MethodPut, path, form); code != http.StatusBadRequest {
+		t.Errorf("Invalid clipboard setting: %d", code)
+	}
+	form.Set("clipboard", "ask")
+	form.Set("address", "10.0.0.3\nProxyCommand touch /tmp/x")
+	if code := serverRequest(app, http.MethodPut, path, form); code != http.StatusBadRequest {
+		t.Errorf("Address with an option: %d", code)
+	}
+	if server, _ := app.Db.GetServer(int(id)); server.Name != "web" || server.Clipboard != "ask" {
+		t.Errorf("Server changed by a rejected update: %+v", server)
+	}
+}
+
+func TestCloneServer(t *testing.T) {
+	app := newTestApp(t)
+	id, _ := app.Db.AddServer(&database.Server{Name: "web", Address: "10.0.0.1", Port: 22, User: "root", Password: "secret"})
+	path := "/server/" + strconv.FormatInt(id, 10) + "/clone"
+
+	for _, name := range []string{"web (2)", "web (3)"} {
+		if code := serverRequest(app, http.MethodPost, path, nil); code != http.StatusOK {
+			t.Fatalf("Clone: %d", code)
+		}
+		if unique, _ := app.Db.IsNameUnique(name); unique {
+			t.Errorf("Clone %q not added", name)
+		}
+	}
+	servers, _ := app.Db.ServerList()
+	for _, server := range servers {
+		if server.Address != "10.0.0.1" || server.Password != "secret" {
+			t.Errorf("Clone differs: %+v", server)
+		}
+	}
+}
+
+func TestValidFileName(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"notes.txt", true},
+		{"żółć .log", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{"a\nC0644 1 b", false},
+		{"a\rb", false},
+		{"a\x00b", false},
+	} {
+		if result := validFileName(test.name); result != test.valid {
+			t.Errorf("validFileName(%q): %v want: %v", test.name, result, test.valid)
+		}
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	app := newTestApp(t)
+	app.Themes = []theme.Theme{{Name: "dark"}, {Name: "light"}}
+	app.Settings = database.Settings{Theme: &app.Themes[0], FontSize: 14, Bell: database.BELL_NONE, Scrollback: 1000}
+	want := app.Settings
+
+	for _, form := range []url.Values{
+		{"font_size": {"20"}, "theme": {"1"}, "bell": {database.BELL_SOUND}, "scrollback": {"-1"}, "max_upload": {"10"}},
+		{"font_size": {"20"}, "theme": {"2"}, "bell": {database.BELL_SOUND}, "scrollback": {"500"}, "max_upload": {"10"}},
+		{"font_size": {"20"}, "theme": {"1"}, "bell": {database.BELL_SOUND}, "scrollback": {"500"}, "max_upload": {"big"}},
+		{"font_size": {"0"}, "theme": {"1"}, "bell": {database.BELL_SOUND}, "scrollback": {"500"}, "max_upload": {"10"}},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ApplySettings(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Invalid settings %v: %d", form, w.Code)
+		}
+		if app.Settings != want {
+			t.Errorf("Settings changed by %v: %+v", form, app.Settings)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const AUDIT_TABLE = `CREATE TABLE IF NOT EXISTS audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INTEGER NOT NULL,
+			session TEXT NOT NULL,
+			server TEXT NOT NULL,
+			address TEXT NOT NULL,
+			user TEXT NOT NULL,
+			event TEXT NOT NULL,
+			auth TEXT NOT NULL DEFAULT '',
+			remote TEXT NOT NULL DEFAULT '',
+			bytesIn INTEGER NOT NULL DEFAULT 0,
+			bytesOut INTEGER NOT NULL DEFAULT 0,
+			detail TEXT NOT NULL DEFAULT ''
+			)`
+
+const AUDIT_COLUMNS = `id, time, session, server, address, user, event, auth, remote, bytesIn, bytesOut, detail`
+
+// Audit events
+const (
+	AUDIT_CONNECT        = "connect"
+	AUDIT_CONNECT_FAILED = "connect_failed"
+	AUDIT_DISCONNECT     = "disconnect"
+	AUDIT_INPUT          = "input" // a line typed in the session, when enabled in the settings
+	AUDIT_EXEC           = "exec"  // a command run without the shell
+)
+
+// SSH authentication methods
+const (
+	AUTH_PASSWORD  = "password"
+	AUTH_PUBLICKEY = "publickey" // the identity file, falling back to the password
+)
+
+const AUDIT_LIMIT = 1000
+
+// AuditEntry is one event of a session. Server, Address and User describe the
+// SSH server, Remote is the address of the browser which opened the session.
+type AuditEntry struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Session  string    `json:"session"`
+	Server   string    `json:"server"`
+	Address  string    `json:"address"`
+	User     string    `json:"user"`
+	Event    string    `json:"event"`
+	Auth     string    `json:"auth"`
+	Remote   string    `json:"remote"`
+	BytesIn  int64     `json:"bytes_in"`  // sent to the server, in disconnect events
+	BytesOut int64     `json:"bytes_out"` // received from the server, in disconnect events
+	Detail   string    `json:"detail"`
+}
+
+// AuditFilter selects audit entries, zero fields match everything. A zero Limit
+// returns AUDIT_LIMIT entries and a negative one all of them.
+type AuditFilter struct {
+	Server  string // substring of the server name
+	Event   string
+	Session string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+func (db *Database) AddAudit(e *AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	_, err := db.conn.ExecContext(
+		context.Background(),
+		`INSERT INTO audit (time, session, server, address, user, event, auth, remote, bytesIn, bytesOut, detail) VALUES (?,?,?,?,?,?,?,?,?,?,?);`,
+		e.Time.UnixMilli(), e.Session, e.Server, e.Address, e.User, e.Event, e.Auth, e.Remote, e.BytesIn, e.BytesOut, e.Detail,
+	)
+	return err
+}
+
+// AuditList returns the entries matching the filter, the newest first.
+func (db *Database) AuditList(f AuditFilter) ([]AuditEntry, error) {
+	conditions := []string{}
+	args := []any{}
+	if len(f.Server) > 0 {
+		conditions = append(conditions, "instr(server, ?) > 0")
+		args = append(args, f.Server)
+	}
+	if len(f.Event) > 0 {
+		conditions = append(conditions, "event = ?")
+		args = append(args, f.Event)
+	}
+	if len(f.Session) > 0 {
+		conditions = append(conditions, "session = ?")
+		args = append(args, f.Session)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+	query := `SELECT ` + AUDIT_COLUMNS + ` FROM audit`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	limit := f.Limit
+	if limit == 0 {
+		limit = AUDIT_LIMIT
+	}
+	query += ` ORDER BY time DESC, id DESC LIMIT ?;`
+	args = append(args, limit)
+
+	rows, err := db.conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var millis int64
+		err := rows.Scan(&e.ID, &millis, &e.Session, &e.Server, &e.Address, &e.User, &e.Event, &e.Auth, &e.Remote, &e.BytesIn, &e.BytesOut, &e.Detail)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(millis)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
@@ -26,10 +27,49 @@ func Open(path string) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	_, err = db.conn.ExecContext(context.Background(), AUDIT_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.conn.ExecContext(context.Background(), SNIPPET_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	err = db.migrate()
+	if err != nil {
+		return nil, err
+	}
 
 	return &db, nil
 }
 
+// Columns added after the first release, applied to databases created before them.
+var MIGRATIONS = []string{
+	`ALTER TABLE server ADD COLUMN clipboard TEXT NOT NULL DEFAULT 'ask'`,
+	`ALTER TABLE settings ADD COLUMN bell TEXT NOT NULL DEFAULT 'visual'`,
+	`ALTER TABLE settings ADD COLUMN scrollback INTEGER NOT NULL DEFAULT 1000`,
+	`ALTER TABLE server ADD COLUMN record TEXT NOT NULL DEFAULT 'off'`,
+	`ALTER TABLE settings ADD COLUMN auditInput INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE settings ADD COLUMN maxUpload INTEGER NOT NULL DEFAULT 1024`,
+	`ALTER TABLE server ADD COLUMN forwards TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE snippet ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE snippet ADD COLUMN server INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE server ADD COLUMN identityFile TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE server ADD COLUMN proxyJump TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE server ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE server ADD COLUMN scrollback INTEGER`,
+}
+
+func (db *Database) migrate() error {
+	for _, migration := range MIGRATIONS {
+		_, err := db.conn.ExecContext(context.Background(), migration)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *Database) Close() error {
 	return db.conn.Close()
 }
@@ -3,7 +3,9 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"strings"
+	"unicode"
 )
 
 const SERVER_TABLE = `CREATE TABLE IF NOT EXISTS server (
@@ -12,15 +14,46 @@ const SERVER_TABLE = `CREATE TABLE IF NOT EXISTS server (
 			port INTEGER NOT NULL, 
 			user TEXT NOT NULL,
 			password TEXT NOT NULL,  
-			name TEXT NOT NULL
+			name TEXT NOT NULL,
+			clipboard TEXT NOT NULL DEFAULT 'ask',
+			record TEXT NOT NULL DEFAULT 'off',
+			forwards TEXT NOT NULL DEFAULT '',
+			identityFile TEXT NOT NULL DEFAULT '',
+			proxyJump TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			scrollback INTEGER
 			)`
 
+const SERVER_COLUMNS = `id, address, port, user, password, name, clipboard, record, forwards, identityFile, proxyJump, tags, scrollback`
+
+// OSC 52 clipboard access policy
+const (
+	CLIPBOARD_ASK   = "ask"
+	CLIPBOARD_ALLOW = "allow"
+	CLIPBOARD_DENY  = "deny"
+)
+
+// Session recording started on connect
+const (
+	RECORD_OFF    = "off"
+	RECORD_OUTPUT = "output" // output and resizes
+	RECORD_INPUT  = "input"  // output, resizes and keyboard input
+)
+
 type Server struct {
-	Address  string
-	Port     uint16
-	User     string
-	Password string
-	Name     string
+	Address   string
+	Port      uint16
+	User      string
+	Password  string
+	Name      string
+	Clipboard string
+	Record    string
+	Forwards  string // port forwardings started on connect, one "-L port:host:hostport" per line
+	// IdentityFile is a private key tried before the password, which is its passphrase when encrypted
+	IdentityFile string
+	ProxyJump    string // jump hosts, "[user@]host[:port]" separated by commas
+	Tags         string // separated by commas
+	Scrollback   *int   // rows kept above the screen, nil uses the settings
 }
 
 type ServerDbRow struct {
@@ -28,6 +61,36 @@ type ServerDbRow struct {
 	Server
 }
 
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// TagList returns the tags without the spaces around them.
+func (s Server) TagList() []string {
+	return splitTags(s.Tags)
+}
+
+// CheckConnection rejects an address, a user or jump hosts with whitespace or
+// control characters and an identity file with control characters or quotes,
+// they would add options to an exported ssh_config.
+func (s Server) CheckConnection() error {
+	for _, field := range []struct{ name, value string }{{"address", s.Address}, {"user", s.User}, {"jump hosts", s.ProxyJump}} {
+		if strings.ContainsFunc(field.value, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+			return errors.New("Invalid " + field.name)
+		}
+	}
+	if strings.ContainsFunc(s.IdentityFile, func(r rune) bool { return unicode.IsControl(r) || r == '"' }) {
+		return errors.New("Invalid identity file")
+	}
+	return nil
+}
+
+func scanServer(row scanner) (ServerDbRow, error) {
+	var server ServerDbRow
+	err := row.Scan(&server.ID, &server.Address, &server.Port, &server.User, &server.Password, &server.Name, &server.Clipboard, &server.Record, &server.Forwards, &server.IdentityFile, &server.ProxyJump, &server.Tags, &server.Scrollback)
+	return server, err
+}
+
 type ServerOrDir struct {
 	Name   string
 	Server ServerDbRow
@@ -35,11 +98,27 @@ type ServerOrDir struct {
 	Childs []*ServerOrDir
 }
 
+// execer runs the statements on the database or in a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func (db *Database) AddServer(s *Server) (int64, error) {
+	return addServer(db.conn, s)
+}
+
+func addServer(conn execer, s *Server) (int64, error) {
+	if len(s.Clipboard) == 0 {
+		s.Clipboard = CLIPBOARD_ASK
+	}
+	if len(s.Record) == 0 {
+		s.Record = RECORD_OFF
+	}
 
-	result, err := db.conn.ExecContext(
+	result, err := conn.ExecContext(
 		context.Background(),
-		`INSERT INTO server (address, port, user, password, name) VALUES (?,?,?,?,?);`, s.Address, s.Port, s.User, s.Password, s.Name,
+		`INSERT INTO server (address, port, user, password, name, clipboard, record, forwards, identityFile, proxyJump, tags, scrollback) VALUES (?,?,?,?,?,?,?,?,?,?,?,?);`, s.Address, s.Port, s.User, s.Password, s.Name, s.Clipboard, s.Record, s.Forwards, s.IdentityFile, s.ProxyJump, s.Tags, s.Scrollback,
 	)
 
 	if err != nil {
@@ -55,6 +134,62 @@ func (db *Database) AddServer(s *Server) (int64, error) {
 	return id, nil
 }
 
+func (db *Database) UpdateServer(ID int, s *Server) error {
+	return updateServer(db.conn, ID, s)
+}
+
+func updateServer(conn execer, ID int, s *Server) error {
+	if len(s.Clipboard) == 0 {
+		s.Clipboard = CLIPBOARD_ASK
+	}
+	if len(s.Record) == 0 {
+		s.Record = RECORD_OFF
+	}
+	_, err := conn.ExecContext(
+		context.Background(),
+		`UPDATE server SET address = ?, port = ?, user = ?, password = ?, name = ?, clipboard = ?, record = ?, forwards = ?, identityFile = ?, proxyJump = ?, tags = ?, scrollback = ? WHERE id = ?;`, s.Address, s.Port, s.User, s.Password, s.Name, s.Clipboard, s.Record, s.Forwards, s.IdentityFile, s.ProxyJump, s.Tags, s.Scrollback, ID,
+	)
+	return err
+}
+
+// UpsertServers updates the servers with the same names or adds them, all or
+// none. An empty password keeps the stored one.
+func (db *Database) UpsertServers(servers []Server) (added, updated int, err error) {
+	tx, err := db.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	for i := range servers {
+		created, err := upsertServer(tx, &servers[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		if created {
+			added++
+		} else {
+			updated++
+		}
+	}
+	return added, updated, tx.Commit()
+}
+
+// upsertServer returns true when the server was added.
+func upsertServer(conn execer, s *Server) (bool, error) {
+	row := conn.QueryRowContext(context.Background(), "SELECT "+SERVER_COLUMNS+" FROM server WHERE name = ?", s.Name)
+	existing, err := scanServer(row)
+	if err == sql.ErrNoRows {
+		_, err = addServer(conn, s)
+		return true, err
+	} else if err != nil {
+		return false, err
+	}
+	if len(s.Password) == 0 {
+		s.Password = existing.Password
+	}
+	return false, updateServer(conn, existing.ID, s)
+}
+
 func (db *Database) DeleteServer(ID int) error {
 
 	_, err := db.conn.ExecContext(
@@ -74,18 +209,15 @@ func (db *Database) ServerList() ([]ServerDbRow, error) {
 
 	rows, err := db.conn.QueryContext(
 		context.Background(),
-		`SELECT * FROM server;`)
+		`SELECT `+SERVER_COLUMNS+` FROM server;`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var server ServerDbRow
-
-		if err := rows.Scan(
-			&server.ID, &server.Address, &server.Port, &server.User, &server.Password, &server.Name,
-		); err != nil {
+		server, err := scanServer(rows)
+		if err != nil {
 			return nil, err
 		}
 		servers = append(servers, server)
@@ -99,16 +231,14 @@ func (db *Database) ServerListWithDirs() ([]*ServerOrDir, error) {
 	dirMap := map[string]*ServerOrDir{}
 	rows, err := db.conn.QueryContext(
 		context.Background(),
-		`SELECT * FROM server ORDER by name ASC;`)
+		`SELECT `+SERVER_COLUMNS+` FROM server ORDER by name ASC;`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var server ServerDbRow
-		if err := rows.Scan(
-			&server.ID, &server.Address, &server.Port, &server.User, &server.Password, &server.Name,
-		); err != nil {
+		server, err := scanServer(rows)
+		if err != nil {
 			return nil, err
 		}
 		name_s := strings.Split(server.Name, "/")
@@ -148,9 +278,8 @@ func (db *Database) ServerListWithDirs() ([]*ServerOrDir, error) {
 }
 
 func (db *Database) GetServer(ID int) (ServerDbRow, error) {
-	row := db.conn.QueryRow("SELECT * FROM server WHERE id = ?", ID)
-	var server ServerDbRow
-	err := row.Scan(&server.ID, &server.Address, &server.Port, &server.User, &server.Password, &server.Name)
+	row := db.conn.QueryRow("SELECT "+SERVER_COLUMNS+" FROM server WHERE id = ?", ID)
+	server, err := scanServer(row)
 	if err != nil {
 		return ServerDbRow{}, err
 	}
@@ -158,7 +287,12 @@ func (db *Database) GetServer(ID int) (ServerDbRow, error) {
 }
 
 func (db *Database) IsNameUnique(name string) (bool, error) {
-	row := db.conn.QueryRow("SELECT id FROM server WHERE name = ?", name)
+	return db.IsNameUniqueExcept(name, 0)
+}
+
+// IsNameUniqueExcept ignores the server with the ID, which keeps its name when edited.
+func (db *Database) IsNameUniqueExcept(name string, ID int) (bool, error) {
+	row := db.conn.QueryRow("SELECT id FROM server WHERE name = ? AND id != ?", name, ID)
 	var id int
 	err := row.Scan(&id)
 	if err == sql.ErrNoRows {
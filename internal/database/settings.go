@@ -10,13 +10,34 @@ const SETTINGS_TABLE = `CREATE TABLE IF NOT EXISTS settings (
 			id INTEGER PRIMARY KEY AUTOINCREMENT, 
 			theme TEXT NOT NULL, 
 			fontSize INTEGER NOT NULL, 
-			openInNewWindow INTEGER NOT NULL
+			openInNewWindow INTEGER NOT NULL,
+			bell TEXT NOT NULL DEFAULT 'visual',
+			scrollback INTEGER NOT NULL DEFAULT 1000,
+			auditInput INTEGER NOT NULL DEFAULT 0,
+			maxUpload INTEGER NOT NULL DEFAULT 1024
 			)`
 
+const MAX_SCROLLBACK = 100000
+
+// Default size cap of dropped files in MiB
+const DEFAULT_MAX_UPLOAD = 1024
+
+// Bell notification modes
+const (
+	BELL_NONE         = "none"
+	BELL_VISUAL       = "visual"
+	BELL_SOUND        = "sound"
+	BELL_NOTIFICATION = "notification"
+)
+
 type Settings struct {
 	Theme           *theme.Theme
 	FontSize        uint
 	OpenInNewWindow bool
+	Bell            string
+	Scrollback      int  // rows kept above the screen
+	AuditInput      bool // log the lines typed in sessions
+	MaxUpload       int  // size cap of dropped files in MiB, 0 for no limit
 }
 
 var settings_id int64
@@ -24,7 +45,7 @@ var settings_id int64
 func (db *Database) UpdateSettings(s *Settings) (int64, error) {
 	result, err := db.conn.ExecContext(
 		context.Background(),
-		`UPDATE settings SET theme = ?, fontSize = ?, openInNewWindow = ? WHERE id = ?`, s.Theme.Name, s.FontSize, s.OpenInNewWindow, settings_id)
+		`UPDATE settings SET theme = ?, fontSize = ?, openInNewWindow = ?, bell = ?, scrollback = ?, auditInput = ?, maxUpload = ? WHERE id = ?`, s.Theme.Name, s.FontSize, s.OpenInNewWindow, s.Bell, s.Scrollback, s.AuditInput, s.MaxUpload, settings_id)
 	if err != nil {
 		return -1, err
 	}
@@ -34,12 +55,12 @@ func (db *Database) UpdateSettings(s *Settings) (int64, error) {
 func (db *Database) GetSettings(defaultSettings Settings, themes []theme.Theme) (Settings, error) {
 	var theme_name string
 	settings := defaultSettings
-	row := db.conn.QueryRow("SELECT id, theme, fontSize, openInNewWindow FROM settings")
-	err := row.Scan(&settings_id, &theme_name, &settings.FontSize, &settings.OpenInNewWindow)
+	row := db.conn.QueryRow("SELECT id, theme, fontSize, openInNewWindow, bell, scrollback, auditInput, maxUpload FROM settings")
+	err := row.Scan(&settings_id, &theme_name, &settings.FontSize, &settings.OpenInNewWindow, &settings.Bell, &settings.Scrollback, &settings.AuditInput, &settings.MaxUpload)
 	if err == sql.ErrNoRows {
 		res, err := db.conn.ExecContext(
 			context.Background(),
-			`INSERT INTO settings (theme, fontSize, openInNewWindow) VALUES (?,?,?)`, settings.Theme.Name, settings.FontSize, settings.OpenInNewWindow)
+			`INSERT INTO settings (theme, fontSize, openInNewWindow, bell, scrollback, auditInput, maxUpload) VALUES (?,?,?,?,?,?,?)`, settings.Theme.Name, settings.FontSize, settings.OpenInNewWindow, settings.Bell, settings.Scrollback, settings.AuditInput, settings.MaxUpload)
 
 		if err != nil {
 			return settings, err
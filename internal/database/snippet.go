@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const SNIPPET_TABLE = `CREATE TABLE IF NOT EXISTS snippet (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			body TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '',
+			server INTEGER NOT NULL DEFAULT 0
+			)`
+
+const SNIPPET_COLUMNS = `id, name, body, tags, server`
+
+// Parameters of a snippet are written {{name}} in its body.
+var snippetParam = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Snippet is a saved command. Tags are separated by commas, a ServerID of 0
+// makes the snippet usable on all servers.
+type Snippet struct {
+	Name     string
+	Body     string
+	Tags     string
+	ServerID int
+}
+
+// SnippetFilter selects snippets, zero fields match everything. Server selects
+// the snippets usable on the server: the global ones and those scoped to it.
+type SnippetFilter struct {
+	Server int
+	Tag    string
+	Text   string // substring of the name or the body
+}
+
+type SnippetDbRow struct {
+	ID int
+	Snippet
+}
+
+// TagList returns the tags without the spaces around them.
+func (s Snippet) TagList() []string {
+	return splitTags(s.Tags)
+}
+
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Params returns the parameter names of the body, in the order of their first use.
+func (s Snippet) Params() []string {
+	return SnippetParams(s.Body)
+}
+
+func SnippetParams(body string) []string {
+	params := []string{}
+	for _, match := range snippetParam.FindAllStringSubmatch(body, -1) {
+		if !slices.Contains(params, match[1]) {
+			params = append(params, match[1])
+		}
+	}
+	return params
+}
+
+// ExpandSnippet replaces the parameters of the body with their values, missing ones are left empty.
+func ExpandSnippet(body string, values map[string]string) string {
+	return snippetParam.ReplaceAllStringFunc(body, func(param string) string {
+		return values[snippetParam.FindStringSubmatch(param)[1]]
+	})
+}
+
+func (db *Database) AddSnippet(s *Snippet) (int64, error) {
+	result, err := db.conn.ExecContext(
+		context.Background(),
+		`INSERT INTO snippet (name, body, tags, server) VALUES (?,?,?,?);`, s.Name, s.Body, s.Tags, s.ServerID,
+	)
+	if err != nil {
+		return -1, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Database) UpdateSnippet(ID int, s *Snippet) error {
+	_, err := db.conn.ExecContext(
+		context.Background(),
+		`UPDATE snippet SET name = ?, body = ?, tags = ?, server = ? WHERE id = ?;`, s.Name, s.Body, s.Tags, s.ServerID, ID,
+	)
+	return err
+}
+
+func (db *Database) DeleteSnippet(ID int) error {
+	_, err := db.conn.ExecContext(
+		context.Background(),
+		`DELETE FROM snippet WHERE id == ?;`, ID,
+	)
+	return err
+}
+
+func (db *Database) GetSnippet(ID int) (SnippetDbRow, error) {
+	return scanSnippet(db.conn.QueryRow("SELECT "+SNIPPET_COLUMNS+" FROM snippet WHERE id = ?", ID))
+}
+
+func scanSnippet(row scanner) (SnippetDbRow, error) {
+	var snippet SnippetDbRow
+	err := row.Scan(&snippet.ID, &snippet.Name, &snippet.Body, &snippet.Tags, &snippet.ServerID)
+	return snippet, err
+}
+
+// SnippetList returns the snippets matching the filter, sorted by name.
+func (db *Database) SnippetList(f SnippetFilter) ([]SnippetDbRow, error) {
+	conditions := []string{}
+	args := []any{}
+	if f.Server != 0 {
+		conditions = append(conditions, "server IN (0, ?)")
+		args = append(args, f.Server)
+	}
+	if len(f.Text) > 0 {
+		conditions = append(conditions, "(instr(lower(name), lower(?)) > 0 OR instr(body, ?) > 0)")
+		args = append(args, f.Text, f.Text)
+	}
+	query := `SELECT ` + SNIPPET_COLUMNS + ` FROM snippet`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY name ASC;`
+
+	rows, err := db.conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []SnippetDbRow{}
+	for rows.Next() {
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		// tags are matched whole, which SQL can't do on the comma separated list
+		if len(f.Tag) > 0 && !slices.ContainsFunc(snippet.TagList(), func(tag string) bool { return strings.EqualFold(tag, f.Tag) }) {
+			continue
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, rows.Err()
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnippetParams(t *testing.T) {
+	for _, test := range []struct {
+		body string
+		want []string
+	}{
+		{"ls -la", []string{}},
+		{"ssh {{host}}", []string{"host"}},
+		{"scp {{ file }} {{host}}:{{file}}", []string{"file", "host"}},
+		{"echo {{_x1}} {{1x}} {{a-b}} {host}", []string{"_x1"}},
+	} {
+		if result := SnippetParams(test.body); !reflect.DeepEqual(result, test.want) {
+			t.Errorf("SnippetParams(%q): %q want: %q", test.body, result, test.want)
+		}
+	}
+}
+
+func TestExpandSnippet(t *testing.T) {
+	values := map[string]string{"host": "web", "file": "a b.txt"}
+	for _, test := range []struct {
+		body string
+		want string
+	}{
+		{"ls -la", "ls -la"},
+		{"ssh {{host}}", "ssh web"},
+		{"scp {{ file }} {{host}}:{{file}}", "scp a b.txt web:a b.txt"},
+		{"echo {{missing}}.", "echo ."},
+		{"echo {host} {{1x}}", "echo {host} {{1x}}"},
+	} {
+		if result := ExpandSnippet(test.body, values); result != test.want {
+			t.Errorf("ExpandSnippet(%q): %q want: %q", test.body, result, test.want)
+		}
+	}
+}
+
+func TestSnippetList(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "potato.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, snippet := range []Snippet{
+		{Name: "disk", Body: "df -h", Tags: "monitoring, Disk"},
+		{Name: "logs", Body: "journalctl -f", Tags: "monitoring", ServerID: 1},
+		{Name: "deploy", Body: "make deploy", Tags: "release", ServerID: 2},
+		{Name: "backup", Body: "tar czf backup.tgz .", Tags: "diskless"},
+	} {
+		if _, err := db.AddSnippet(&snippet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		filter SnippetFilter
+		want   []string
+	}{
+		{SnippetFilter{}, []string{"backup", "deploy", "disk", "logs"}},
+		{SnippetFilter{Server: 1}, []string{"backup", "disk", "logs"}},
+		{SnippetFilter{Server: 3}, []string{"backup", "disk"}},
+		{SnippetFilter{Tag: "monitoring"}, []string{"disk", "logs"}},
+		{SnippetFilter{Tag: "disk"}, []string{"disk"}},
+		{SnippetFilter{Tag: "monitoring", Server: 2}, []string{"disk"}},
+		{SnippetFilter{Text: "DEPLOY"}, []string{"deploy"}},
+		{SnippetFilter{Tag: "none"}, []string{}},
+	} {
+		snippets, err := db.SnippetList(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, snippet := range snippets {
+			names = append(names, snippet.Name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("SnippetList(%+v): %q want: %q", test.filter, names, test.want)
+		}
+	}
+}
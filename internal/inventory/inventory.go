@@ -0,0 +1,342 @@
+// Package inventory exports the server tree as JSON or CSV, to keep it in a
+// repository, and reads it back.
+package inventory
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"potatossh/internal/database"
+	"potatossh/internal/session"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_CSV  = "csv"
+)
+
+// SECRET_PREFIX starts the encrypted passwords: the salt, the nonce and the
+// AES-GCM ciphertext follow in base64.
+const SECRET_PREFIX = "scrypt:"
+
+var CSV_HEADER = []string{"folder", "name", "address", "port", "user", "tags", "clipboard", "record", "forwards", "identity_file", "proxy_jump", "scrollback", "password"}
+
+// Item is a server of the inventory, Folder is the path of its directory in
+// the server tree. Password is only exported on request, encrypted.
+type Item struct {
+	Folder       string   `json:"folder,omitempty"`
+	Name         string   `json:"name"`
+	Address      string   `json:"address"`
+	Port         uint16   `json:"port"`
+	User         string   `json:"user"`
+	Tags         []string `json:"tags,omitempty"`
+	Clipboard    string   `json:"clipboard,omitempty"`
+	Record       string   `json:"record,omitempty"`
+	Forwards     []string `json:"forwards,omitempty"`
+	IdentityFile string   `json:"identity_file,omitempty"`
+	ProxyJump    string   `json:"proxy_jump,omitempty"`
+	Scrollback   *int     `json:"scrollback,omitempty"`
+	Password     string   `json:"password,omitempty"`
+}
+
+type inventory struct {
+	Servers []Item `json:"servers"`
+}
+
+// Export lists the servers of the tree, folder by folder. The passwords are
+// encrypted with the secrets, or left out when it's nil.
+func Export(tree []*database.ServerOrDir, secrets *Secrets) ([]Item, error) {
+	items := []Item{}
+	err := export(tree, "", secrets, &items)
+	return items, err
+}
+
+func export(tree []*database.ServerOrDir, folder string, secrets *Secrets, items *[]Item) error {
+	for _, node := range tree {
+		if node.Dir {
+			if err := export(node.Childs, strings.TrimPrefix(folder+"/"+node.Name, "/"), secrets, items); err != nil {
+				return err
+			}
+			continue
+		}
+		server := node.Server
+		item := Item{
+			Folder:       folder,
+			Name:         node.Name,
+			Address:      server.Address,
+			Port:         server.Port,
+			User:         server.User,
+			Tags:         server.TagList(),
+			Clipboard:    server.Clipboard,
+			Record:       server.Record,
+			Forwards:     lines(server.Forwards),
+			IdentityFile: server.IdentityFile,
+			ProxyJump:    server.ProxyJump,
+			Scrollback:   server.Scrollback,
+		}
+		if secrets != nil && len(server.Password) > 0 {
+			var err error
+			if item.Password, err = secrets.Encrypt(server.Password); err != nil {
+				return err
+			}
+		}
+		*items = append(*items, item)
+	}
+	return nil
+}
+
+func lines(s string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Server checks the item and returns its server, named with its folder. The
+// password is decrypted with the secrets.
+func (i Item) Server(secrets *Secrets) (database.Server, error) {
+	name := strings.TrimSpace(i.Name)
+	folder := strings.Trim(strings.TrimSpace(i.Folder), "/")
+	if len(name) == 0 || strings.Contains(name, "/") {
+		return database.Server{}, fmt.Errorf("invalid name %q", i.Name)
+	}
+	if len(folder) > 0 {
+		if slices.Contains(strings.Split(folder, "/"), "") {
+			return database.Server{}, fmt.Errorf("%s: invalid folder %q", name, i.Folder)
+		}
+		name = folder + "/" + name
+	}
+	server := database.Server{
+		Name:         name,
+		Address:      strings.TrimSpace(i.Address),
+		Port:         i.Port,
+		User:         i.User,
+		Clipboard:    i.Clipboard,
+		Record:       i.Record,
+		Forwards:     strings.Join(i.Forwards, "\n"),
+		IdentityFile: i.IdentityFile,
+		ProxyJump:    i.ProxyJump,
+		Scrollback:   i.Scrollback,
+		Tags:         strings.Join(database.Server{Tags: strings.Join(i.Tags, ",")}.TagList(), ", "),
+	}
+	if server.Port == 0 {
+		server.Port = 22
+	}
+	if len(server.Address) == 0 {
+		return server, fmt.Errorf("%s: missing address", name)
+	}
+	if err := server.CheckConnection(); err != nil {
+		return server, fmt.Errorf("%s: %w", name, err)
+	}
+	if !slices.Contains([]string{"", database.CLIPBOARD_ASK, database.CLIPBOARD_ALLOW, database.CLIPBOARD_DENY}, server.Clipboard) {
+		return server, fmt.Errorf("%s: invalid clipboard %q", name, server.Clipboard)
+	}
+	if !slices.Contains([]string{"", database.RECORD_OFF, database.RECORD_OUTPUT, database.RECORD_INPUT}, server.Record) {
+		return server, fmt.Errorf("%s: invalid record %q", name, server.Record)
+	}
+	if server.Scrollback != nil && (*server.Scrollback < 0 || *server.Scrollback > database.MAX_SCROLLBACK) {
+		return server, fmt.Errorf("%s: invalid scrollback %d", name, *server.Scrollback)
+	}
+	if _, err := session.ParseForwards(server.Forwards); err != nil {
+		return server, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(i.Password) > 0 {
+		if secrets == nil {
+			return server, fmt.Errorf("%s: the password is encrypted, a passphrase is needed", name)
+		}
+		var err error
+		if server.Password, err = secrets.Decrypt(i.Password); err != nil {
+			return server, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return server, nil
+}
+
+// WriteJSON writes the items indented, for readable diffs.
+func WriteJSON(w io.Writer, items []Item) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(inventory{Servers: items})
+}
+
+// WriteCSV writes a row for each item, the tags are separated by commas and
+// the forwards by new lines.
+func WriteCSV(w io.Writer, items []Item) error {
+	writer := csv.NewWriter(w)
+	writer.Write(CSV_HEADER)
+	for _, i := range items {
+		scrollback := ""
+		if i.Scrollback != nil {
+			scrollback = strconv.Itoa(*i.Scrollback)
+		}
+		writer.Write([]string{i.Folder, i.Name, i.Address, strconv.Itoa(int(i.Port)), i.User, strings.Join(i.Tags, ", "), i.Clipboard, i.Record, strings.Join(i.Forwards, "\n"), i.IdentityFile, i.ProxyJump, scrollback, i.Password})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Read reads a JSON or a CSV inventory, JSON starts with a brace. CSV columns
+// are found by the names of CSV_HEADER, missing ones are left empty.
+func Read(r io.Reader) ([]Item, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var inv inventory
+		if err := json.Unmarshal(trimmed, &inv); err != nil {
+			return nil, err
+		}
+		return inv.Servers, nil
+	}
+	return readCSV(data)
+}
+
+func readCSV(data []byte) ([]Item, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []Item{}, nil
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("missing name column")
+	}
+	items := []Item{}
+	for n, record := range records[1:] {
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		item := Item{
+			Folder:       field("folder"),
+			Name:         field("name"),
+			Address:      field("address"),
+			User:         field("user"),
+			Tags:         database.Server{Tags: field("tags")}.TagList(),
+			Clipboard:    field("clipboard"),
+			Record:       field("record"),
+			Forwards:     lines(field("forwards")),
+			IdentityFile: field("identity_file"),
+			ProxyJump:    field("proxy_jump"),
+			Password:     field("password"),
+		}
+		if port := field("port"); len(port) > 0 {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid port %q", n+2, port)
+			}
+			item.Port = uint16(p)
+		}
+		if scrollback := field("scrollback"); len(scrollback) > 0 {
+			rows, err := strconv.Atoi(scrollback)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid scrollback %q", n+2, scrollback)
+			}
+			item.Scrollback = &rows
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Secrets encrypts and decrypts passwords with a key derived from a
+// passphrase. An export uses one salt, so the key is derived once.
+type Secrets struct {
+	passphrase string
+	salt       []byte
+	keys       map[string][]byte // by salt
+}
+
+func NewSecrets(passphrase string) *Secrets {
+	return &Secrets{passphrase: passphrase, keys: map[string][]byte{}}
+}
+
+func (s *Secrets) key(salt []byte) ([]byte, error) {
+	if key, ok := s.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(s.passphrase), salt, 32768, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	s.keys[string(salt)] = key
+	return key, nil
+}
+
+func (s *Secrets) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := s.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Secrets) Encrypt(secret string) (string, error) {
+	if s.salt == nil {
+		s.salt = make([]byte, 16)
+		if _, err := rand.Read(s.salt); err != nil {
+			return "", err
+		}
+	}
+	aead, err := s.aead(s.salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := append(append(slices.Clone(s.salt), nonce...), aead.Seal(nil, nonce, []byte(secret), nil)...)
+	return SECRET_PREFIX + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (s *Secrets) Decrypt(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, SECRET_PREFIX)
+	if !ok {
+		return "", errors.New("the password isn't encrypted")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < 16 {
+		return "", errors.New("invalid encrypted password")
+	}
+	aead, err := s.aead(data[:16])
+	if err != nil {
+		return "", err
+	}
+	data = data[16:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted password")
+	}
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong passphrase")
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,120 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"potatossh/internal/database"
+	"strings"
+	"testing"
+)
+
+func tree() []*database.ServerOrDir {
+	web := database.ServerDbRow{ID: 1, Server: database.Server{Name: "prod/eu/web", Address: "web.example.com", Port: 22, User: "deploy",
+		Password: "secret", Clipboard: "ask", Record: "off", Forwards: "-L localhost:8080:localhost:80\n-D localhost:1080", Tags: "web, eu"}}
+	scrollback := 5000
+	db := database.ServerDbRow{ID: 2, Server: database.Server{Name: "db", Address: "10.0.0.5", Port: 2222, User: "root",
+		Clipboard: "deny", Record: "input", IdentityFile: "~/.ssh/id_db", ProxyJump: "admin@bastion:22", Scrollback: &scrollback}}
+	return []*database.ServerOrDir{
+		{Name: "prod", Dir: true, Childs: []*database.ServerOrDir{
+			{Name: "eu", Dir: true, Childs: []*database.ServerOrDir{{Name: "web", Server: web}}},
+		}},
+		{Name: "db", Server: db},
+	}
+}
+
+// encode compares items regardless of nil and empty slices.
+func encode(items []Item) string {
+	data, _ := json.Marshal(items)
+	return string(data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	items, err := Export(tree(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Folder != "prod/eu" || items[0].Name != "web" || items[0].Password != "" {
+		t.Fatalf("export: %+v", items)
+	}
+
+	for _, format := range []string{FORMAT_JSON, FORMAT_CSV} {
+		var out bytes.Buffer
+		if format == FORMAT_JSON {
+			err = WriteJSON(&out, items)
+		} else {
+			err = WriteCSV(&out, items)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := Read(&out)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got, want := encode(read), encode(items); got != want {
+			t.Errorf("%s: got %s, want %s", format, got, want)
+		}
+		server, err := read[0].Server(nil)
+		if err != nil || server.Name != "prod/eu/web" || server.Tags != "web, eu" || server.Forwards != tree()[0].Childs[0].Childs[0].Server.Forwards {
+			t.Errorf("%s: server %+v %v", format, server, err)
+		}
+		if server, err := read[1].Server(nil); err != nil || server.Scrollback == nil || *server.Scrollback != 5000 {
+			t.Errorf("%s: scrollback %+v %v", format, server, err)
+		}
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	items, err := Export(tree(), NewSecrets("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(items[0].Password, SECRET_PREFIX) || strings.Contains(items[0].Password, "secret") {
+		t.Fatalf("password not encrypted: %q", items[0].Password)
+	}
+	if items[1].Password != "" {
+		t.Errorf("empty password exported: %q", items[1].Password)
+	}
+	if server, err := items[0].Server(NewSecrets("passphrase")); err != nil || server.Password != "secret" {
+		t.Errorf("decrypt: %q %v", server.Password, err)
+	}
+	if _, err := items[0].Server(NewSecrets("wrong")); err == nil {
+		t.Error("decrypted with a wrong passphrase")
+	}
+	if _, err := items[0].Server(nil); err == nil {
+		t.Error("imported an encrypted password without a passphrase")
+	}
+	if _, err := (Item{Name: "plain", Address: "a", Password: "secret"}).Server(NewSecrets("passphrase")); err == nil {
+		t.Error("imported a plain text password")
+	}
+}
+
+func TestInvalidItems(t *testing.T) {
+	negative := -1
+	for _, item := range []Item{
+		{Name: "", Address: "a"},
+		{Name: "a/b", Address: "a"},
+		{Name: "a", Folder: "x//y", Address: "a"},
+		{Name: "a"},
+		{Name: "a", Address: "a", Clipboard: "always"},
+		{Name: "a", Address: "a", Forwards: []string{"-L nonsense"}},
+		{Name: "a", Address: "a", Scrollback: &negative},
+		{Name: "a", Address: "a\nProxyCommand x"},
+		{Name: "a", Address: "a", User: "me x"},
+	} {
+		if _, err := item.Server(nil); err == nil {
+			t.Errorf("%+v: accepted", item)
+		}
+	}
+
+	items, err := Read(strings.NewReader("Name,Address\nweb,10.0.0.1\n"))
+	if err != nil || len(items) != 1 {
+		t.Fatal(items, err)
+	}
+	if server, err := items[0].Server(nil); err != nil || server.Port != 22 || server.Address != "10.0.0.1" {
+		t.Errorf("partial CSV: %+v %v", server, err)
+	}
+	if _, err := Read(strings.NewReader("address\n10.0.0.1\n")); err == nil {
+		t.Error("CSV without name column accepted")
+	}
+}
@@ -0,0 +1,272 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Directory where the recordings are stored, relative to the working directory.
+const DIR = "recordings"
+
+const EXTENSION = ".cast"
+
+// asciicast v2 event types
+const (
+	EVENT_OUTPUT = "o"
+	EVENT_INPUT  = "i"
+	EVENT_RESIZE = "r"
+)
+
+// Header is the first line of an asciicast v2 file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	// IdleTimeLimit is the longest pause kept by players, in seconds.
+	IdleTimeLimit float64 `json:"idle_time_limit,omitempty"`
+}
+
+// Event is one line after the header, Time is in seconds since the start.
+type Event struct {
+	Time float64
+	Kind string
+	Data string
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return errors.New("an event should have 3 fields")
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Kind); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Read parses an asciicast v2 file.
+func Read(r io.Reader) (Header, []Event, error) {
+	var header Header
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return header, nil, scanner.Err()
+		}
+		return header, nil, errors.New("missing header")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, err
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	events := []Event{}
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return header, events, scanner.Err()
+}
+
+// ParseSize parses the data of a resize event.
+func ParseSize(data string) (columns, rows int, err error) {
+	_, err = fmt.Sscanf(data, "%dx%d", &columns, &rows)
+	return columns, rows, err
+}
+
+// Recorder writes a session as an asciicast v2 file, it is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	Name    string
+	file    io.WriteCloser
+	start   time.Time
+	input   bool
+	partial map[string][]byte // incomplete UTF-8 sequences at the end of the last chunk
+	err     error
+}
+
+func New(file io.WriteCloser, width, height int, title string, input bool) (*Recorder, error) {
+	r := &Recorder{
+		file:    file,
+		start:   time.Now(),
+		input:   input,
+		partial: map[string][]byte{},
+	}
+	header, err := json.Marshal(Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "%s\n", header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Create starts a recording in a new file in the directory, named after the title and the current time.
+func Create(dir, title string, width, height int, input bool) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s%s", fileName(title), time.Now().Format("20060102-150405.000"), EXTENSION)
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(file, width, height, title, input)
+	if err != nil {
+		return nil, err
+	}
+	r.Name = name
+	return r, nil
+}
+
+// fileName replaces the characters which are not safe in file names.
+func fileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, title)
+	return strings.TrimLeft(name, ".")
+}
+
+func (r *Recorder) Output(p []byte) {
+	r.event(EVENT_OUTPUT, p)
+}
+
+// Input records the keys sent to the server, when enabled.
+func (r *Recorder) Input(p []byte) {
+	if r.input {
+		r.event(EVENT_INPUT, p)
+	}
+}
+
+func (r *Recorder) Resize(columns, rows int) {
+	r.event(EVENT_RESIZE, []byte(fmt.Sprintf("%dx%d", columns, rows)))
+}
+
+func (r *Recorder) event(kind string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	// a chunk can end in the middle of a character, the event data has to be valid UTF-8
+	data := append(r.partial[kind], p...)
+	complete := len(data) - incomplete(data)
+	r.partial[kind] = append([]byte(nil), data[complete:]...)
+	if complete == 0 {
+		return
+	}
+	line, err := json.Marshal([]any{json.Number(fmt.Sprintf("%.6f", time.Since(r.start).Seconds())), kind, string(data[:complete])})
+	if err != nil {
+		r.err = err
+		return
+	}
+	if _, err := fmt.Fprintf(r.file, "%s\n", line); err != nil {
+		fmt.Println("Recording error:", err)
+		r.err = err
+	}
+}
+
+// incomplete returns the length of an unfinished UTF-8 sequence at the end of p.
+func incomplete(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if c < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// Close finishes the recording, later events are dropped.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == os.ErrClosed {
+		return nil
+	}
+	r.err = os.ErrClosed
+	return r.file.Close()
+}
+
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// List returns the recordings in the directory, the newest first.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Info{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	recordings := []Info{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), EXTENSION) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, Info{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	slices.SortFunc(recordings, func(a, b Info) int {
+		return b.ModTime.Compare(a.ModTime)
+	})
+	return recordings, nil
+}
+
+// Path returns the path of the recording, or false when the name is not a recording file name.
+func Path(dir, name string) (string, bool) {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, EXTENSION) || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	return filepath.Join(dir, name), true
+}
@@ -0,0 +1,92 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := Create(dir, "web/server 1", 80, 24, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("ż\xc3"))
+	r.Output([]byte("\xb3ł\r\n"))
+	r.Input([]byte("ls\r"))
+	r.Resize(100, 30)
+	r.Close()
+	r.Output([]byte("after close"))
+
+	path, ok := Path(dir, r.Name)
+	if !ok || filepath.Dir(path) != dir {
+		t.Fatalf("Invalid recording name: %#q", r.Name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+
+	scanner.Scan()
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 || header.Width != 80 || header.Height != 24 {
+		t.Errorf("Header: %s error: %v", scanner.Bytes(), err)
+	}
+	want := [][2]string{{EVENT_OUTPUT, "ż"}, {EVENT_OUTPUT, "ół\r\n"}, {EVENT_RESIZE, "100x30"}}
+	for _, w := range want {
+		if !scanner.Scan() {
+			t.Fatalf("Missing event %v", w)
+		}
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("Event: %s error: %v", scanner.Bytes(), err)
+		}
+		if event[1] != w[0] || event[2] != w[1] {
+			t.Errorf("Event: %v want: %v", event[1:], w)
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("Unexpected event: %s", scanner.Bytes())
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, name := range []string{"../x.cast", "a/b.cast", ".cast", "x.txt"} {
+		if _, ok := Path("recordings", name); ok {
+			t.Errorf("Path accepted %#q", name)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24, "idle_time_limit": 1.5}
+[0.5, "o", "a\u001b[1m"]
+
+[1.25, "r", "100x30"]
+`
+	header, events, err := Read(strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Width != 80 || header.IdleTimeLimit != 1.5 {
+		t.Errorf("Header: %+v", header)
+	}
+	want := []Event{{0.5, EVENT_OUTPUT, "a\x1b[1m"}, {1.25, EVENT_RESIZE, "100x30"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Events: %+v want: %+v", events, want)
+	}
+	if columns, rows, err := ParseSize(events[1].Data); err != nil || columns != 100 || rows != 30 {
+		t.Errorf("Size: %dx%d error: %v", columns, rows, err)
+	}
+
+	if _, _, err := Read(strings.NewReader(`{"version": 1}`)); err == nil {
+		t.Errorf("Version 1 accepted")
+	}
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"potatossh/internal/database"
+	"unicode/utf8"
+)
+
+// MAX_AUDIT_LINE limits the characters of an input line kept for the audit log.
+const MAX_AUDIT_LINE = 4096
+
+// SetAudit sets the function called on connection events (database.AUDIT_*).
+// When input is true the lines typed in the session are reported too.
+func (s *Session) SetAudit(audit func(event, detail string), input bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = audit
+	s.auditInput = input
+}
+
+func (s *Session) SetAuditInput(input bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditInput = input
+	s.input_line = inputLine{}
+}
+
+// Transferred returns the number of bytes sent to and received from the server.
+func (s *Session) Transferred() (in, out int64) {
+	return s.bytes_in.Load(), s.bytes_out.Load()
+}
+
+func (s *Session) auditEvent(event, detail string) {
+	s.mu.Lock()
+	audit := s.audit
+	s.mu.Unlock()
+	if audit != nil {
+		audit(event, detail)
+	}
+}
+
+func (s *Session) auditStdin(p []byte) {
+	s.mu.Lock()
+	if !s.auditInput || s.audit == nil {
+		s.mu.Unlock()
+		return
+	}
+	lines := s.input_line.write(p)
+	audit := s.audit
+	s.mu.Unlock()
+	for _, line := range lines {
+		audit(database.AUDIT_INPUT, line)
+	}
+}
+
+// inputLine approximates the lines typed in a shell from the keyboard input:
+// backspace removes the last character, Ctrl+C and Ctrl+U discard the line
+// and escape sequences (e.g. arrows) are ignored.
+type inputLine struct {
+	text   []rune
+	escape bool
+	csi    bool
+}
+
+// write returns the lines finished by enter.
+func (l *inputLine) write(p []byte) []string {
+	lines := []string{}
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		p = p[size:]
+		if l.escape {
+			// ESC [ parameters final or ESC O final
+			if !l.csi && (r == '[' || r == 'O') {
+				l.csi = true
+				continue
+			}
+			if !l.csi || r >= '@' && r <= '~' {
+				l.escape, l.csi = false, false
+			}
+			continue
+		}
+		switch {
+		case r == '\x1b':
+			l.escape = true
+		case r == '\r' || r == '\n':
+			if len(l.text) > 0 {
+				lines = append(lines, string(l.text))
+			}
+			l.text = l.text[:0]
+		case r == '\x7f' || r == '\b':
+			if len(l.text) > 0 {
+				l.text = l.text[:len(l.text)-1]
+			}
+		case r == '\x03' || r == '\x15':
+			l.text = l.text[:0]
+		case r >= ' ' && len(l.text) < MAX_AUDIT_LINE:
+			l.text = append(l.text, r)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"potatossh/internal/database"
+	"reflect"
+	"testing"
+)
+
+func TestInputLine(t *testing.T) {
+	line := inputLine{}
+	result := line.write([]byte("ls -l\rcd /tnp\x7f\x7fmp\x1b[A\x1bOB\r"))
+	result = append(result, line.write([]byte("secret\x03echo żółw\x1b"))...)
+	result = append(result, line.write([]byte("[D\r\r"))...)
+	want := []string{"ls -l", "cd /tmp", "echo żółw"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Input lines: %#q want: %#q", result, want)
+	}
+}
+
+func TestAuditInput(t *testing.T) {
+	s, stdin, writer := newTestSession(t)
+	defer writer.Close()
+	events := [][2]string{}
+	s.SetAudit(func(event, detail string) {
+		events = append(events, [2]string{event, detail})
+	}, false)
+
+	s.InjectStdin([]byte("hidden\r"))
+	s.SetAuditInput(true)
+	s.InjectStdin([]byte("whoami\r"))
+	waitStdin(t, stdin, "hidden\rwhoami\r")
+
+	want := [][2]string{{database.AUDIT_INPUT, "whoami"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Audit events: %#q want: %#q", events, want)
+	}
+	if in, _ := s.Transferred(); in != 14 {
+		t.Errorf("Bytes sent: %d", in)
+	}
+}
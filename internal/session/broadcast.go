@@ -0,0 +1,120 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Broadcast is a group of sessions sharing the keyboard input: the keys typed
+// in a member are also written to the other members.
+type Broadcast struct {
+	mu      sync.Mutex
+	members []*Session
+}
+
+func NewBroadcast() *Broadcast {
+	return &Broadcast{}
+}
+
+func (b *Broadcast) Add(s *Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.setBroadcast(b) {
+		b.members = append(b.members, s)
+	}
+}
+
+func (b *Broadcast) Remove(s *Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, member := range b.members {
+		if member == s {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			s.setBroadcast(nil)
+			return
+		}
+	}
+}
+
+// Toggle adds the session or removes it when it's a member, it returns true when added.
+func (b *Broadcast) Toggle(s *Session) bool {
+	if s.Broadcasting() {
+		b.Remove(s)
+		return false
+	}
+	b.Add(s)
+	return true
+}
+
+// Clear removes all the members and returns them.
+func (b *Broadcast) Clear() []*Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	members := b.members
+	b.members = nil
+	for _, member := range members {
+		member.setBroadcast(nil)
+	}
+	return members
+}
+
+func (b *Broadcast) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.members)
+}
+
+func (b *Broadcast) others(s *Session) []*Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	others := make([]*Session, 0, len(b.members))
+	for _, member := range b.members {
+		if member != s {
+			others = append(others, member)
+		}
+	}
+	return others
+}
+
+// setBroadcast sets the group of the session, it returns false when it's already in one.
+func (s *Session) setBroadcast(b *Broadcast) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b != nil && s.broadcast != nil {
+		return false
+	}
+	s.broadcast = b
+	return true
+}
+
+// Broadcasting returns true when the keyboard input is shared with a group.
+func (s *Session) Broadcasting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.broadcast != nil
+}
+
+// broadcastStdin writes keys typed in the session to the other members of its group.
+func (s *Session) broadcastStdin(keys []byte) {
+	s.mu.Lock()
+	b := s.broadcast
+	s.mu.Unlock()
+	if b == nil {
+		return
+	}
+	for _, member := range b.others(s) {
+		if err := member.InjectStdin(keys); err != nil && err != ErrNotConnected {
+			fmt.Println("Broadcast error:", err)
+		}
+	}
+}
+
+// leaveBroadcast removes the session from its group.
+func (s *Session) leaveBroadcast() {
+	s.mu.Lock()
+	b := s.broadcast
+	s.mu.Unlock()
+	if b != nil {
+		b.Remove(s)
+	}
+}
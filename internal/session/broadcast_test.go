@@ -0,0 +1,43 @@
+package session
+
+import "testing"
+
+func TestBroadcast(t *testing.T) {
+	a, stdinA, writerA := newTestSession(t)
+	defer writerA.Close()
+	b, stdinB, writerB := newTestSession(t)
+	defer writerB.Close()
+	c, stdinC, writerC := newTestSession(t)
+	defer writerC.Close()
+
+	group := NewBroadcast()
+	group.Add(a)
+	group.Add(b)
+	group.Add(b)
+	if group.Len() != 2 || !a.Broadcasting() || c.Broadcasting() {
+		t.Fatalf("Members: %d", group.Len())
+	}
+	a.broadcastStdin([]byte("ls\r"))
+	c.broadcastStdin([]byte("pwd\r"))
+	if stdinA.String() != "" || stdinB.String() != "ls\r" || stdinC.String() != "" {
+		t.Errorf("Stdin: %q %q %q", stdinA.String(), stdinB.String(), stdinC.String())
+	}
+
+	if group.Toggle(b) || b.Broadcasting() {
+		t.Error("Toggle didn't exclude the tab")
+	}
+	a.broadcastStdin([]byte("x"))
+	if stdinB.String() != "ls\r" {
+		t.Errorf("Excluded tab received %q", stdinB.String())
+	}
+	if !group.Toggle(c) {
+		t.Error("Toggle didn't include the tab")
+	}
+	c.leaveBroadcast()
+	if group.Len() != 1 || c.Broadcasting() {
+		t.Errorf("Members after leaving: %d", group.Len())
+	}
+	if members := group.Clear(); len(members) != 1 || a.Broadcasting() {
+		t.Errorf("Cleared: %v", members)
+	}
+}
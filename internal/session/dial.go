@@ -0,0 +1,140 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"potatossh/internal/database"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clientConfig authenticates with the identity file of the server, when set,
+// then with the password.
+func clientConfig(user string, server database.Server) (*ssh.ClientConfig, error) {
+	auth := []ssh.AuthMethod{}
+	if len(server.IdentityFile) > 0 {
+		signer, err := loadIdentity(server.IdentityFile, server.Password)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	auth = append(auth, ssh.Password(server.Password))
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil
+}
+
+// jumpConfig authenticates with the identity file of the server only, its
+// password is never sent to a jump host. It decrypts the key locally.
+func jumpConfig(user string, server database.Server) (*ssh.ClientConfig, error) {
+	if len(server.IdentityFile) == 0 {
+		return nil, errors.New("jump hosts need an identity file")
+	}
+	signer, err := loadIdentity(server.IdentityFile, server.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil
+}
+
+// loadIdentity reads a private key, the passphrase is used when it's encrypted.
+func loadIdentity(path, passphrase string) (ssh.Signer, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("identity file %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// parseJump splits a "[user@]host[:port]" jump host, user is the default user.
+func parseJump(jump, user string) (string, string) {
+	if at := strings.LastIndex(jump, "@"); at >= 0 {
+		user, jump = jump[:at], jump[at+1:]
+	}
+	if _, _, err := net.SplitHostPort(jump); err != nil {
+		jump = net.JoinHostPort(strings.Trim(jump, "[]"), "22")
+	}
+	return user, jump
+}
+
+// dialServer connects to the server, through its jump hosts if any. The jump
+// hosts use the identity file of the server and are closed with the connection.
+func dialServer(server database.Server) (*ssh.Client, error) {
+	config, err := clientConfig(server.User, server)
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(server.Address, strconv.Itoa(int(server.Port)))
+	jumps := strings.TrimSpace(server.ProxyJump)
+	if len(jumps) == 0 || strings.EqualFold(jumps, "none") {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	var client *ssh.Client
+	for _, jump := range strings.Split(jumps, ",") {
+		user, jumpAddress := parseJump(strings.TrimSpace(jump), server.User)
+		jumpConfig, err := jumpConfig(user, server)
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, err
+		}
+		if client, err = dialVia(client, jumpAddress, jumpConfig); err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", jumpAddress, err)
+		}
+	}
+	return dialVia(client, address, config)
+}
+
+// dialVia connects through the client, directly when it's nil. The client is
+// closed when the new connection fails or ends.
+func dialVia(via *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", address, config)
+	}
+	conn, err := via.Dial("tcp", address)
+	if err != nil {
+		via.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		via.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		client.Wait()
+		via.Close()
+	}()
+	return client, nil
+}
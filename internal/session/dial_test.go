@@ -0,0 +1,70 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"potatossh/internal/database"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseJump(t *testing.T) {
+	tests := []struct {
+		jump, user, address string
+	}{
+		{"bastion", "me", "bastion:22"},
+		{"admin@bastion:2222", "admin", "bastion:2222"},
+		{"[::1]", "me", "[::1]:22"},
+		{"a@b@[::1]:2200", "a@b", "[::1]:2200"},
+	}
+	for _, test := range tests {
+		user, address := parseJump(test.jump, "me")
+		if user != test.user || address != test.address {
+			t.Errorf("%q: got %q %q", test.jump, user, address)
+		}
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	plain, _ := ssh.MarshalPrivateKey(key, "")
+	encrypted, _ := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	os.WriteFile(filepath.Join(dir, "plain"), pem.EncodeToMemory(plain), 0600)
+	os.WriteFile(filepath.Join(dir, "encrypted"), pem.EncodeToMemory(encrypted), 0600)
+
+	if _, err := loadIdentity(filepath.Join(dir, "plain"), ""); err != nil {
+		t.Error("plain key:", err)
+	}
+	if _, err := loadIdentity(filepath.Join(dir, "encrypted"), "secret"); err != nil {
+		t.Error("encrypted key:", err)
+	}
+	if _, err := loadIdentity(filepath.Join(dir, "encrypted"), "wrong"); err == nil {
+		t.Error("encrypted key loaded with a wrong passphrase")
+	}
+}
+
+func TestJumpConfig(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+	encrypted, _ := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	os.WriteFile(filepath.Join(dir, "encrypted"), pem.EncodeToMemory(encrypted), 0600)
+
+	if _, err := jumpConfig("me", database.Server{Password: "secret"}); err == nil {
+		t.Error("jump host without an identity file")
+	}
+	config, err := jumpConfig("me", database.Server{Password: "secret", IdentityFile: filepath.Join(dir, "encrypted")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Auth) != 1 {
+		t.Errorf("jump host auth methods: %d, the password would be sent", len(config.Auth))
+	}
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"potatossh/internal/database"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Limits of commands run with Exec
+const (
+	EXEC_TIMEOUT    = time.Minute
+	MAX_EXEC_OUTPUT = 1024 * 1024 // bytes kept of stdout and of stderr
+)
+
+// ExecResult is the outcome of a command run without a PTY. ExitCode is -1
+// when the command didn't exit normally, Error tells why.
+type ExecResult struct {
+	Command   string
+	Stdout    string
+	Stderr    string
+	Truncated bool // the output was longer than MAX_EXEC_OUTPUT
+	ExitCode  int
+	Error     string
+	Started   time.Time
+	Duration  time.Duration
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := min(len(p), b.max-len(b.buf))
+	b.buf = append(b.buf, p[:n]...)
+	if n < len(p) {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// content returns the kept bytes and whether some were dropped.
+func (b *limitedBuffer) content() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf), b.truncated
+}
+
+// Exec runs the command on a new channel of the session's connection, next to
+// the interactive shell. The command is killed after the timeout.
+func (s *Session) Exec(command string, timeout time.Duration) ExecResult {
+	result := ExecResult{Command: command, ExitCode: -1, Started: time.Now()}
+	s.mu.Lock()
+	client := s.ssh_client
+	s.mu.Unlock()
+	if client == nil {
+		result.Error = ErrNotConnected.Error()
+		return result
+	}
+	s.auditEvent(database.AUDIT_EXEC, command)
+
+	err := runCommand(client, command, timeout, &result)
+	result.Duration = time.Since(result.Started)
+	var exit *ssh.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exit):
+		result.ExitCode = exit.ExitStatus()
+		if len(exit.Signal()) > 0 {
+			result.Error = "killed by signal " + exit.Signal()
+		}
+	default:
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func runCommand(client *ssh.Client, command string, timeout time.Duration, result *ExecResult) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout := &limitedBuffer{max: MAX_EXEC_OUTPUT}
+	stderr := &limitedBuffer{max: MAX_EXEC_OUTPUT}
+	session.Stdout, session.Stderr = stdout, stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	var stdoutTruncated, stderrTruncated bool
+	result.Stdout, stdoutTruncated = stdout.content()
+	result.Stderr, stderrTruncated = stderr.content()
+	result.Truncated = stdoutTruncated || stderrTruncated
+	return err
+}
+
+// Elapsed returns the duration rounded for display.
+func (r ExecResult) Elapsed() string {
+	return r.Duration.Round(time.Millisecond).String()
+}
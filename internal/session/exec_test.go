@@ -0,0 +1,27 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	for _, p := range []string{"abc", "def", "gh"} {
+		if n, err := b.Write([]byte(p)); n != len(p) || err != nil {
+			t.Errorf("Write %q: %d %v", p, n, err)
+		}
+	}
+	if content, truncated := b.content(); content != "abcde" || !truncated {
+		t.Errorf("Content: %q %v", content, truncated)
+	}
+}
+
+func TestExecNotConnected(t *testing.T) {
+	s, _, writer := newTestSession(t)
+	defer writer.Close()
+	result := s.Exec("true", time.Second)
+	if result.ExitCode != -1 || result.Error != ErrNotConnected.Error() {
+		t.Errorf("Result: %+v", result)
+	}
+}
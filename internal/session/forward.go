@@ -0,0 +1,444 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Port forwarding kinds, named after the ssh options
+const (
+	FORWARD_LOCAL   = "L" // listen locally, connect from the server
+	FORWARD_REMOTE  = "R" // listen on the server, connect locally
+	FORWARD_DYNAMIC = "D" // SOCKS5 proxy listening locally, connect from the server
+)
+
+// FORWARD_BIND is the listen address when the spec doesn't set one.
+const FORWARD_BIND = "localhost"
+
+// Forward is a port forwarding in the ssh syntax: "-L [bind:]port:host:hostport",
+// "-R [bind:]port:host:hostport" or "-D [bind:]port". Target is empty for dynamic ones.
+type Forward struct {
+	Kind   string
+	Listen string // host:port
+	Target string // host:port
+}
+
+func (f Forward) String() string {
+	if f.Kind == FORWARD_DYNAMIC {
+		return "-D " + f.Listen
+	}
+	return "-" + f.Kind + " " + f.Listen + ":" + f.Target
+}
+
+// ParseForward parses one forwarding spec, the dash of the option is optional.
+func ParseForward(spec string) (Forward, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return Forward{}, fmt.Errorf("invalid forwarding %q, expected \"-L port:host:hostport\"", spec)
+	}
+	f := Forward{Kind: strings.ToUpper(strings.TrimPrefix(fields[0], "-"))}
+	parts := splitForward(fields[1])
+	switch {
+	case f.Kind == FORWARD_DYNAMIC && len(parts) == 1:
+		parts = append([]string{FORWARD_BIND}, parts...)
+	case f.Kind == FORWARD_DYNAMIC && len(parts) == 2:
+	case (f.Kind == FORWARD_LOCAL || f.Kind == FORWARD_REMOTE) && len(parts) == 3:
+		parts = append([]string{FORWARD_BIND}, parts...)
+	case (f.Kind == FORWARD_LOCAL || f.Kind == FORWARD_REMOTE) && len(parts) == 4:
+	default:
+		return Forward{}, fmt.Errorf("invalid forwarding %q", spec)
+	}
+	if !validPort(parts[1], true) || len(parts) == 4 && (len(parts[2]) == 0 || !validPort(parts[3], false)) {
+		return Forward{}, fmt.Errorf("invalid port in forwarding %q", spec)
+	}
+	if len(parts[0]) == 0 {
+		parts[0] = FORWARD_BIND
+	}
+	f.Listen = net.JoinHostPort(parts[0], parts[1])
+	if len(parts) == 4 {
+		f.Target = net.JoinHostPort(parts[2], parts[3])
+	}
+	return f, nil
+}
+
+// ParseForwards parses one spec per line, empty lines are ignored.
+func ParseForwards(text string) ([]Forward, error) {
+	forwards := []Forward{}
+	for _, line := range strings.Split(text, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		f, err := ParseForward(line)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// splitForward splits on the colons outside of the brackets around IPv6 addresses.
+func splitForward(s string) []string {
+	parts := []string{}
+	start, brackets := 0, false
+	for i, c := range s {
+		switch c {
+		case '[':
+			brackets = true
+		case ']':
+			brackets = false
+		case ':':
+			if !brackets {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	for i, part := range parts {
+		parts[i] = strings.TrimSuffix(strings.TrimPrefix(part, "["), "]")
+	}
+	return parts
+}
+
+func validPort(s string, zero bool) bool {
+	port, err := strconv.ParseUint(s, 10, 16)
+	return err == nil && (zero || port > 0)
+}
+
+// forwarder runs a forwarding: it accepts the connections of the listener and
+// connects them to the target with dial. Stop closes the listener and the
+// open connections.
+type forwarder struct {
+	id       int
+	forward  Forward
+	listener net.Listener
+	dial     func(addr string) (net.Conn, error)
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	total    int
+	err      error
+	stopped  bool
+}
+
+// ForwardInfo describes a running forwarding, Active and Total count the connections.
+type ForwardInfo struct {
+	Id int
+	Forward
+	Active int
+	Total  int
+	Error  string
+}
+
+func newForwarder(f Forward, listener net.Listener, dial func(addr string) (net.Conn, error)) *forwarder {
+	fw := &forwarder{forward: f, listener: listener, dial: dial, conns: map[net.Conn]struct{}{}}
+	go fw.serve()
+	return fw
+}
+
+// startForwarder listens on the local machine or, for remote forwardings, on the server.
+func startForwarder(f Forward, client *ssh.Client) (*forwarder, error) {
+	switch f.Kind {
+	case FORWARD_LOCAL, FORWARD_DYNAMIC:
+		listener, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			return nil, err
+		}
+		return newForwarder(f, listener, func(addr string) (net.Conn, error) {
+			return client.Dial("tcp", addr)
+		}), nil
+	case FORWARD_REMOTE:
+		listener, err := client.Listen("tcp", f.Listen)
+		if err != nil {
+			return nil, err
+		}
+		return newForwarder(f, listener, func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		}), nil
+	}
+	return nil, fmt.Errorf("invalid forwarding kind %q", f.Kind)
+}
+
+func (f *forwarder) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			f.mu.Lock()
+			if !f.stopped {
+				f.err = err
+			}
+			f.mu.Unlock()
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *forwarder) handle(conn net.Conn) {
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		conn.Close()
+		return
+	}
+	f.conns[conn] = struct{}{}
+	f.total++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	target := f.forward.Target
+	if f.forward.Kind == FORWARD_DYNAMIC {
+		var err error
+		if target, err = socksRequest(conn); err != nil {
+			fmt.Println("SOCKS error:", err)
+			return
+		}
+	}
+	remote, err := f.dial(target)
+	if f.forward.Kind == FORWARD_DYNAMIC {
+		socksReply(conn, err)
+	}
+	if err != nil {
+		fmt.Printf("Forwarding %s: %s\n", f.forward, err)
+		return
+	}
+	pipe(conn, remote)
+}
+
+// pipe copies both ways until one side is closed.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+func (f *forwarder) stop() {
+	f.mu.Lock()
+	f.stopped = true
+	conns := f.conns
+	f.conns = map[net.Conn]struct{}{}
+	f.mu.Unlock()
+	if f.listener != nil {
+		f.listener.Close()
+	}
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+func (f *forwarder) info() ForwardInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info := ForwardInfo{Id: f.id, Forward: f.forward, Active: len(f.conns), Total: f.total}
+	if f.err != nil {
+		info.Error = f.err.Error()
+	}
+	return info
+}
+
+// SOCKS5 (RFC 1928) without authentication, only CONNECT is supported.
+const (
+	SOCKS_VERSION       = 5
+	SOCKS_CONNECT       = 1
+	SOCKS_ATYP_IPV4     = 1
+	SOCKS_ATYP_DOMAIN   = 3
+	SOCKS_ATYP_IPV6     = 4
+	SOCKS_NO_AUTH       = 0
+	SOCKS_NO_METHOD     = 0xff
+	SOCKS_SUCCEEDED     = 0
+	SOCKS_FAILURE       = 1
+	SOCKS_NOT_SUPPORTED = 7
+	SOCKS_BAD_ADDRESS   = 8
+	SOCKS_REPLY_ADDRESS = "\x01\x00\x00\x00\x00\x00\x00" // IPv4 0.0.0.0:0
+)
+
+var errSocks = errors.New("invalid SOCKS5 request")
+
+// socksRequest negotiates the method and returns the address requested by the client.
+func socksRequest(conn net.Conn) (string, error) {
+	// read unbuffered, the data following the request is piped to the target
+	r := conn
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != SOCKS_VERSION {
+		return "", errSocks
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+	if !strings.ContainsRune(string(methods), SOCKS_NO_AUTH) {
+		conn.Write([]byte{SOCKS_VERSION, SOCKS_NO_METHOD})
+		return "", errors.New("SOCKS5 client requires authentication")
+	}
+	if _, err := conn.Write([]byte{SOCKS_VERSION, SOCKS_NO_AUTH}); err != nil {
+		return "", err
+	}
+
+	// version, command, reserved and address type
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil {
+		return "", err
+	}
+	if request[0] != SOCKS_VERSION {
+		return "", errSocks
+	}
+	var host string
+	switch request[3] {
+	case SOCKS_ATYP_IPV4, SOCKS_ATYP_IPV6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == SOCKS_ATYP_IPV6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case SOCKS_ATYP_DOMAIN:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksWrite(conn, SOCKS_BAD_ADDRESS)
+		return "", errSocks
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	if request[1] != SOCKS_CONNECT {
+		socksWrite(conn, SOCKS_NOT_SUPPORTED)
+		return "", fmt.Errorf("unsupported SOCKS5 command %d", request[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func socksReply(conn net.Conn, err error) {
+	if err != nil {
+		socksWrite(conn, SOCKS_FAILURE)
+		return
+	}
+	socksWrite(conn, SOCKS_SUCCEEDED)
+}
+
+func socksWrite(conn net.Conn, code byte) {
+	conn.Write(append([]byte{SOCKS_VERSION, code, 0}, SOCKS_REPLY_ADDRESS...))
+}
+
+// AddForward starts a forwarding on the session's connection.
+func (s *Session) AddForward(f Forward) error {
+	s.mu.Lock()
+	client := s.ssh_client
+	s.mu.Unlock()
+	if client == nil {
+		return ErrNotConnected
+	}
+	forwarder, err := startForwarder(f, client)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ssh_client != client {
+		// disconnected meanwhile
+		forwarder.stop()
+		return ErrNotConnected
+	}
+	s.forward_id++
+	forwarder.id = s.forward_id
+	s.forwards = append(s.forwards, forwarder)
+	return nil
+}
+
+// StopForward stops the forwarding and closes its connections.
+func (s *Session) StopForward(id int) bool {
+	s.mu.Lock()
+	var stopped *forwarder
+	for i, forwarder := range s.forwards {
+		if forwarder.id == id {
+			stopped = forwarder
+			s.forwards = append(s.forwards[:i], s.forwards[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	if stopped == nil {
+		return false
+	}
+	stopped.stop()
+	return true
+}
+
+// Forwards returns the running forwardings, in the order they were started.
+func (s *Session) Forwards() []ForwardInfo {
+	s.mu.Lock()
+	forwarders := append([]*forwarder{}, s.forwards...)
+	s.mu.Unlock()
+	infos := make([]ForwardInfo, 0, len(forwarders))
+	for _, forwarder := range forwarders {
+		infos = append(infos, forwarder.info())
+	}
+	return infos
+}
+
+// startForwards starts the forwardings of the server. The shell is usable
+// without them, so the failed ones are only shown in the forwardings panel.
+func (s *Session) startForwards() {
+	forwards, err := ParseForwards(s.Server.Forwards)
+	if err != nil {
+		fmt.Println("Forwarding error:", err)
+		return
+	}
+	for _, f := range forwards {
+		err := s.AddForward(f)
+		if err == nil || errors.Is(err, ErrNotConnected) {
+			continue
+		}
+		fmt.Printf("Forwarding %s: %s\n", f, err)
+		s.mu.Lock()
+		s.forward_id++
+		s.forwards = append(s.forwards, &forwarder{id: s.forward_id, forward: f, conns: map[net.Conn]struct{}{}, err: err})
+		s.mu.Unlock()
+	}
+}
+
+func (s *Session) stopForwards() {
+	s.mu.Lock()
+	forwarders := s.forwards
+	s.forwards = nil
+	s.mu.Unlock()
+	for _, forwarder := range forwarders {
+		forwarder.stop()
+	}
+}
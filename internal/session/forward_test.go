@@ -0,0 +1,158 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"-L 8080:db:5432", "-L localhost:8080:db:5432"},
+		{"L 0.0.0.0:8080:db:5432", "-L 0.0.0.0:8080:db:5432"},
+		{"-R 9000:localhost:3000", "-R localhost:9000:localhost:3000"},
+		{"-L [::1]:8080:[fe80::1]:80", "-L [::1]:8080:[fe80::1]:80"},
+		{"-D 1080", "-D localhost:1080"},
+		{"-d :1080", "-D localhost:1080"},
+		{"-L 8080:db", ""},
+		{"-L 8080:db:0", ""},
+		{"-X 1080", ""},
+		{"-D 70000", ""},
+		{"-L", ""},
+	}
+	for _, test := range tests {
+		f, err := ParseForward(test.spec)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: parsed as %q", test.spec, f)
+			}
+			continue
+		}
+		if err != nil || f.String() != test.want {
+			t.Errorf("%q: got %q %v, want %q", test.spec, f, err, test.want)
+		}
+	}
+
+	forwards, err := ParseForwards("-L 8080:db:5432\n\n  -D 1080  \n")
+	if err != nil || len(forwards) != 2 {
+		t.Errorf("ParseForwards: %v %v", forwards, err)
+	}
+}
+
+// echoServer accepts connections and sends back what it receives.
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startTestForwarder(t *testing.T, f Forward) *forwarder {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := newForwarder(f, listener, func(addr string) (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	})
+	t.Cleanup(fw.stop)
+	return fw
+}
+
+func echo(t *testing.T, conn net.Conn, message string) {
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len(message))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != message {
+		t.Fatalf("Echo: %q %v", received, err)
+	}
+}
+
+func waitActive(t *testing.T, fw *forwarder, active, total int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info := fw.info()
+		if info.Active == active && info.Total == total {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Connections: %d/%d, want %d/%d", info.Active, info.Total, active, total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocalForward(t *testing.T) {
+	fw := startTestForwarder(t, Forward{Kind: FORWARD_LOCAL, Target: echoServer(t)})
+	conn, err := net.Dial("tcp", fw.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn, "hello")
+	waitActive(t, fw, 1, 1)
+	conn.Close()
+	waitActive(t, fw, 0, 1)
+
+	conn, err = net.Dial("tcp", fw.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, "again")
+	fw.stop()
+	// the open connection is closed with the forwarding
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Connection still open after stop")
+	}
+}
+
+func TestDynamicForward(t *testing.T) {
+	target := echoServer(t)
+	fw := startTestForwarder(t, Forward{Kind: FORWARD_DYNAMIC})
+	conn, err := net.Dial("tcp", fw.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{SOCKS_VERSION, 1, SOCKS_NO_AUTH})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || !bytes.Equal(reply, []byte{SOCKS_VERSION, SOCKS_NO_AUTH}) {
+		t.Fatalf("Method reply: %v %v", reply, err)
+	}
+	host, port, _ := net.SplitHostPort(target)
+	request := []byte{SOCKS_VERSION, SOCKS_CONNECT, 0, SOCKS_ATYP_DOMAIN, byte(len(host))}
+	request = append(request, host...)
+	portNumber, _ := strconv.Atoi(port)
+	request = append(request, byte(portNumber>>8), byte(portNumber))
+	conn.Write(request)
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != SOCKS_SUCCEEDED {
+		t.Fatalf("Connect reply: %v %v", reply, err)
+	}
+	echo(t, conn, "through socks")
+}
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"potatossh/internal/database"
+	"potatossh/internal/recording"
+	"potatossh/internal/terminal"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Playback defaults
+const (
+	IDLE_LIMIT          = 2.0  // seconds, used when the recording doesn't set idle_time_limit
+	CHECKPOINT_INTERVAL = 10.0 // seconds of playback between terminal checkpoints
+	MAX_SPEED           = 16.0
+)
+
+type checkpoint struct {
+	next  int     // index of the first event after the checkpoint
+	time  float64 // playback time of the checkpoint
+	state *terminal.Checkpoint
+}
+
+// Player replays a recording into the session terminal. Times are compressed:
+// pauses between events longer than the idle limit are shortened to it.
+// Seeking restores the last checkpoint before the target and replays the
+// events from there, checkpoints are taken while the events are played.
+type Player struct {
+	mu          sync.Mutex
+	term        *terminal.Terminal
+	events      []recording.Event
+	times       []float64 // compressed time of each event
+	duration    float64
+	next        int       // index of the next event
+	base        float64   // playback time at started
+	started     time.Time // wall time when the playback was resumed
+	paused      bool
+	speed       float64
+	checkpoints []checkpoint
+	wake        chan struct{}
+	stop        chan struct{}
+}
+
+// NewPlayback creates a session which plays the recording instead of connecting to a server.
+func NewPlayback(name string, template *template.Template) (*Session, error) {
+	path, ok := recording.Path(recording.DIR, name)
+	if !ok {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, events, err := recording.Read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSession(database.Server{Name: name, Address: "playback"}, template)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.Title) > 0 {
+		s.term.SetTitle(header.Title)
+	}
+	s.term.SetSize(header.Height, header.Width)
+	// shown like a connected session, replies to the recorded queries are dropped
+	s.term.Connected(nil)
+	idle := header.IdleTimeLimit
+	if idle <= 0 {
+		idle = IDLE_LIMIT
+	}
+	s.player = newPlayer(s.term, events, idle)
+	return s, nil
+}
+
+func newPlayer(term *terminal.Terminal, events []recording.Event, idle float64) *Player {
+	p := &Player{
+		term:    term,
+		events:  events,
+		times:   make([]float64, len(events)),
+		started: time.Now(),
+		speed:   1,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	last, compressed := 0.0, 0.0
+	for i, event := range events {
+		compressed += min(max(event.Time-last, 0), idle)
+		last = event.Time
+		p.times[i] = compressed
+	}
+	p.duration = compressed
+	state, _ := term.Checkpoint()
+	p.checkpoints = []checkpoint{{next: 0, time: 0, state: state}}
+	return p
+}
+
+// run plays the events until Close, notify is called after the terminal changed.
+func (p *Player) run(notify func()) {
+	for {
+		p.mu.Lock()
+		var wait <-chan time.Time
+		if !p.paused && p.next < len(p.events) {
+			delay := (p.times[p.next] - p.now()) / p.speed
+			if delay <= 0 {
+				p.apply()
+				p.mu.Unlock()
+				notify()
+				continue
+			}
+			wait = time.After(time.Duration(delay * float64(time.Second)))
+		} else if !p.paused {
+			// the end of the recording
+			p.base, p.paused = p.duration, true
+		}
+		p.mu.Unlock()
+		select {
+		case <-wait:
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// now returns the playback time.
+func (p *Player) now() float64 {
+	if p.paused {
+		return p.base
+	}
+	return min(p.base+time.Since(p.started).Seconds()*p.speed, p.duration)
+}
+
+// apply plays the next event.
+func (p *Player) apply() {
+	event := p.events[p.next]
+	switch event.Kind {
+	case recording.EVENT_OUTPUT:
+		p.term.Write([]byte(event.Data))
+	case recording.EVENT_RESIZE:
+		if columns, rows, err := recording.ParseSize(event.Data); err == nil {
+			p.term.SetSize(rows, columns)
+		}
+	}
+	p.next++
+	if last := p.checkpoints[len(p.checkpoints)-1]; p.next > last.next && p.times[p.next-1]-last.time >= CHECKPOINT_INTERVAL {
+		if state, ok := p.term.Checkpoint(); ok {
+			p.checkpoints = append(p.checkpoints, checkpoint{next: p.next, time: p.times[p.next-1], state: state})
+		}
+	}
+}
+
+func (p *Player) wakeUp() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Seek moves the playback to the time in seconds.
+func (p *Player) Seek(seconds float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seek(seconds)
+	p.wakeUp()
+}
+
+// Seek moves the playback and shows the result in the browser.
+func (s *Session) Seek(seconds float64) {
+	s.player.Seek(seconds)
+	s.notifyUpdate()
+}
+
+func (p *Player) seek(seconds float64) {
+	seconds = min(max(seconds, 0), p.duration)
+	i := len(p.checkpoints) - 1
+	for p.checkpoints[i].time > seconds {
+		i--
+	}
+	// the current state is used when seeking forward, unless a checkpoint is closer
+	backward := p.next > 0 && p.times[p.next-1] > seconds
+	if backward || p.checkpoints[i].next > p.next {
+		p.term.Restore(p.checkpoints[i].state)
+		p.next = p.checkpoints[i].next
+	}
+	for p.next < len(p.events) && p.times[p.next] <= seconds {
+		p.apply()
+	}
+	p.base, p.started = seconds, time.Now()
+}
+
+func (p *Player) SetPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if paused == p.paused {
+		return
+	}
+	if !paused && p.next == len(p.events) {
+		// replay from the start
+		p.seek(0)
+	}
+	p.base, p.started, p.paused = p.now(), time.Now(), paused
+	p.wakeUp()
+}
+
+func (p *Player) SetSpeed(speed float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.base, p.started = p.now(), time.Now()
+	p.speed = min(max(speed, 1/MAX_SPEED), MAX_SPEED)
+	p.wakeUp()
+}
+
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *Player) Speed() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.speed
+}
+
+// Position returns the playback time in seconds.
+func (p *Player) Position() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now()
+}
+
+// Duration returns the length of the recording in seconds, after the idle time compression.
+func (p *Player) Duration() float64 {
+	return p.duration
+}
+
+func (p *Player) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+}
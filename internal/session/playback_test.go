@@ -0,0 +1,46 @@
+package session
+
+import (
+	"fmt"
+	"potatossh/internal/recording"
+	"potatossh/internal/terminal"
+	"testing"
+)
+
+func TestPlayerSeek(t *testing.T) {
+	events := []recording.Event{}
+	for i := range 100 {
+		events = append(events, recording.Event{Time: float64(i), Kind: recording.EVENT_OUTPUT, Data: fmt.Sprintf("%d\r\n", i)})
+	}
+	// a long pause is compressed to the idle limit
+	events = append(events, recording.Event{Time: 1000, Kind: recording.EVENT_OUTPUT, Data: "end"})
+	term := terminal.NewTerminal("test")
+	term.SetSize(5, 80)
+	player := newPlayer(term, events, 2)
+	player.SetPaused(true)
+	if player.Duration() != 101 {
+		t.Errorf("Duration: %f", player.Duration())
+	}
+
+	last := func() string {
+		screen := term.GetScreen()
+		return screen.Row(screen.Len() - 2).Text()
+	}
+	for _, seek := range []struct {
+		seconds float64
+		want    string
+	}{{50, "50"}, {95.5, "95"}, {20, "20"}, {60, "60"}, {0, "0"}} {
+		player.Seek(seek.seconds)
+		if result := last(); result != seek.want {
+			t.Errorf("Seek to %.1f: %#q want: %#q", seek.seconds, result, seek.want)
+		}
+	}
+	if len(player.checkpoints) < 5 {
+		t.Errorf("Checkpoints: %d", len(player.checkpoints))
+	}
+
+	player.Seek(200)
+	if result := term.GetScreen().GetCurrentRow().Text(); result != "end" || player.Position() != 101 {
+		t.Errorf("Seek past the end: %#q position: %f", result, player.Position())
+	}
+}
@@ -1,47 +1,93 @@
 package session
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"potatossh/internal/database"
+	"potatossh/internal/recording"
 	"potatossh/internal/terminal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
+// Session is shared by the HTTP handlers and its own goroutines: collectStdOut
+// reads the SSH output and, for each attached browser, wsSender renders it,
+// wsPinger keeps the connection alive and sendStdin handles browser messages.
+// The terminal locks itself, mu guards the connection state and settings.
 type Session struct {
 	Id          string
+	Server      database.Server
+	new_data    chan []byte
+	resync      chan struct{}
+	updated     chan struct{} // the terminal was changed by the player
+	done        chan struct{} // closed by Disconnect
+	closing     sync.Once
+	term        *terminal.Terminal
+	template    *template.Template
+	mu          sync.Mutex
 	ws_conn     *websocket.Conn
 	ssh_client  *ssh.Client
 	ssh_session *ssh.Session
+	sftp_client *sftp.Client
 	stdin       io.WriteCloser
 	stdout      io.Reader
-	Server      database.Server
-	new_data    chan rune
-	ws_done     chan struct{}
-	term        *terminal.Terminal
-	template    *template.Template
+	bell        string
+	protocol    string
+	recorder    *recording.Recorder
+	player      *Player
+	audit       func(event, detail string)
+	auditInput  bool
+	input_line  inputLine
+	bytes_in    atomic.Int64 // sent to the server
+	bytes_out   atomic.Int64 // received from the server
+	uploads     []*Upload
+	forwards    []*forwarder
+	forward_id  int // id of the last started forwarding
+	broadcast   *Broadcast
 }
 
-var upgrader = websocket.Upgrader{}
+var ErrNotConnected = errors.New("session not connected")
 
-func connectToHost(user, host, pass string) (*ssh.Client, *ssh.Session, error) {
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{ssh.Password(pass)},
-	}
-	sshConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+// Bracketed paste markers
+const (
+	PASTE_START = "\x1b[200~"
+	PASTE_END   = "\x1b[201~"
+)
+
+// Update protocols selectable by the browser
+const (
+	PROTOCOL_HTML   = "html"   // htmx out of band swaps
+	PROTOCOL_BINARY = "binary" // terminal.UpdateBinary rendered by the browser
+)
+
+// Flow control: the browser acknowledges every update message. Without acks
+// no new frames are sent and after FRAME_BUDGET bytes of output the sender
+// stops reading, so the SSH channel window fills up and the remote program
+// blocks. Buffered output per session is bounded by the SSH window, one read
+// chunk and (MAX_INFLIGHT+1)*FRAME_BUDGET processed bytes.
+const (
+	FRAME_BUDGET = 256 * 1024      // output bytes processed per sent frame
+	MAX_INFLIGHT = 2               // update messages sent without an ack
+	ACK_TIMEOUT  = 5 * time.Second // stop waiting for acks from a broken client
+)
+
+var upgrader = websocket.Upgrader{}
 
-	client, err := ssh.Dial("tcp", host, sshConfig)
+func connectToHost(server database.Server) (*ssh.Client, *ssh.Session, error) {
+	client, err := dialServer(server)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -59,16 +105,20 @@ func NewSession(server database.Server, template *template.Template) (*Session,
 
 	return &Session{
 		Id:          uuid.New().String(),
+		Server:      server,
+		new_data:    make(chan []byte),
+		resync:      make(chan struct{}, 1),
+		updated:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		term:        terminal.NewTerminal(server.Name),
+		template:    template,
 		ws_conn:     nil,
 		ssh_client:  nil,
 		ssh_session: nil,
 		stdin:       nil,
 		stdout:      nil,
-		Server:      server,
-		new_data:    make(chan rune),
-		ws_done:     nil,
-		term:        terminal.NewTerminal(server.Name),
-		template:    template,
+		bell:        database.BELL_VISUAL,
+		protocol:    PROTOCOL_HTML,
 	}, nil
 }
 
@@ -76,6 +126,10 @@ func (s *Session) Start() error {
 
 	fmt.Printf("Staring session %s with %s (%s).\n", s.Id, s.Server.Name, s.Server.Address)
 
+	if s.player != nil {
+		go s.player.run(s.notifyUpdate)
+		return nil
+	}
 	go s.collectStdOut()
 
 	return nil
@@ -86,47 +140,81 @@ func (s *Session) AttachWebSocket(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return err
 	}
-	if s.ws_conn != nil {
+	s.mu.Lock()
+	previous := s.ws_conn
+	s.ws_conn = ws
+	s.mu.Unlock()
+	if previous != nil {
 		// disconnect
 		fmt.Println("Previous WebSocket connection exist. Closing.")
-		s.ws_conn.Close()
+		previous.Close()
 	}
-	s.ws_conn = ws
-	s.ws_done = make(chan struct{})
-	fmt.Printf("The client %s attached to session %s (%s - %s).\n", s.ws_conn.RemoteAddr().String(), s.Id, s.Server.Name, s.Server.Address)
-	go s.wsSender()
-	go s.wsPinger()
-	s.sendStdin()
-	fmt.Printf("The client %s dettached from session %s  (%s - %s).\n", s.ws_conn.RemoteAddr().String(), s.Id, s.Server.Name, s.Server.Address)
-	s.ws_conn = nil
+	s.term.Resync()
+	done := make(chan struct{})
+	acks := make(chan struct{}, MAX_INFLIGHT)
+	fmt.Printf("The client %s attached to session %s (%s - %s).\n", ws.RemoteAddr().String(), s.Id, s.Server.Name, s.Server.Address)
+	go s.wsSender(ws, done, acks)
+	go s.wsPinger(ws, done)
+	s.sendStdin(ws, acks)
+	close(done)
+	fmt.Printf("The client %s dettached from session %s  (%s - %s).\n", ws.RemoteAddr().String(), s.Id, s.Server.Name, s.Server.Address)
+	s.mu.Lock()
+	if s.ws_conn == ws {
+		s.ws_conn = nil
+	}
+	s.mu.Unlock()
 	return nil
 }
 
 func (s *Session) Disconnect() {
-	s.ws_conn.Close()
-	s.ssh_client.Close()
-	s.ssh_session.Close()
+	s.closing.Do(func() { close(s.done) })
+	s.mu.Lock()
+	ws_conn, ssh_client, ssh_session, sftp_client := s.ws_conn, s.ssh_client, s.ssh_session, s.sftp_client
+	s.mu.Unlock()
+	if ws_conn != nil {
+		ws_conn.Close()
+	}
+	s.stopForwards()
+	s.leaveBroadcast()
+	if sftp_client != nil {
+		sftp_client.Close()
+	}
+	if ssh_session != nil {
+		ssh_session.Close()
+	}
+	if ssh_client != nil {
+		ssh_client.Close()
+	}
+	s.StopRecording()
+	if s.player != nil {
+		s.player.Close()
+	}
 }
 
 func (s *Session) connect() error {
 	// connect
-	var err error
-	s.ssh_client, s.ssh_session, err = connectToHost(s.Server.User, fmt.Sprintf("%s:%d", s.Server.Address, s.Server.Port), s.Server.Password)
+	ssh_client, ssh_session, err := connectToHost(s.Server)
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	s.ssh_client, s.ssh_session = ssh_client, ssh_session
+	s.mu.Unlock()
 
 	// pipes
-	s.stdin, err = s.ssh_session.StdinPipe()
+	stdin, err := ssh_session.StdinPipe()
 	if err != nil {
 		return err
 	}
-	s.stdout, err = s.ssh_session.StdoutPipe()
+	stdout, err := ssh_session.StdoutPipe()
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	s.stdin, s.stdout = stdin, stdout
+	s.mu.Unlock()
 
-	s.term.Connected(s.stdin)
+	s.term.Connected(stdin)
 
 	modes := ssh.TerminalModes{
 		ssh.ECHO:          1,     // enable echoing
@@ -135,12 +223,12 @@ func (s *Session) connect() error {
 	}
 
 	rows, columns := s.term.GetSize()
-	err = s.ssh_session.RequestPty("xterm-256color", rows, columns, modes)
+	err = ssh_session.RequestPty("xterm-256color", rows, columns, modes)
 	if err != nil {
 		return err
 	}
 
-	err = s.ssh_session.Shell()
+	err = ssh_session.Shell()
 	if err != nil {
 		return err
 	}
@@ -150,79 +238,239 @@ func (s *Session) connect() error {
 func (s *Session) collectStdOut() {
 	err := s.connect()
 	if err != nil {
+		s.auditEvent(database.AUDIT_CONNECT_FAILED, err.Error())
 		return
 	}
+	s.auditEvent(database.AUDIT_CONNECT, "")
+	s.startForwards()
 
+	s.readOutput(s.stdout)
+	s.auditEvent(database.AUDIT_DISCONNECT, "")
+}
+
+func (s *Session) readOutput(stdout io.Reader) {
 	// producer
-	reader := bufio.NewReader(s.stdout)
+	buffer := make([]byte, 32*1024)
 	for {
-		if c, _, err := reader.ReadRune(); err != nil {
-			fmt.Println(c, err)
-			if err == io.EOF {
+		n, err := stdout.Read(buffer)
+		if n > 0 {
+			s.bytes_out.Add(int64(n))
+			if recorder := s.recording(); recorder != nil {
+				recorder.Output(buffer[:n])
+			}
+			// the consumer keeps the chunk, the buffer is reused for the next read
+			select {
+			case s.new_data <- bytes.Clone(buffer[:n]):
+			case <-s.done:
+				// nobody reads the output anymore
+				fmt.Println("readOutput: disconnected")
 				return
-			} else {
-				log.Fatal(err)
 			}
-		} else {
-			s.new_data <- c
+		}
+		if err != nil {
+			// EOF or the connection was closed by Disconnect
+			fmt.Println("readOutput:", err)
+			return
 		}
 	}
 }
 
+// SetBell sets how bells are shown in the browser (one of database.BELL_*).
+func (s *Session) SetBell(mode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bell = mode
+}
+
+func (s *Session) settings() (bell, protocol string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bell, s.protocol
+}
+
 func (s *Session) Terminal() *terminal.Terminal {
 	return s.term
 }
 
+// Player returns the player of a playback session, nil for SSH sessions.
+func (s *Session) Player() *Player {
+	return s.player
+}
+
+// notifyUpdate makes attached browsers render the terminal changed outside of the output stream.
+func (s *Session) notifyUpdate() {
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+// renderHTML returns the changed rows, or the whole code element after Resync.
 func (s *Session) renderHTML() []byte {
 	var buffer bytes.Buffer
-	s.template.ExecuteTemplate(&buffer, "codeblock", s)
-	if s.term.TitleUpdate {
-		s.term.TitleUpdate = false
+	update, _ := s.term.Update("session_" + s.Id)
+	buffer.WriteString(update)
+	if s.term.TakeTitleUpdate() {
 		s.template.ExecuteTemplate(&buffer, "title_oob", s)
 	}
 	return buffer.Bytes()
 }
 
-func (s *Session) wsSender() {
+// sendUpdate returns the number of update messages sent, each one is acknowledged by the browser.
+func (s *Session) sendUpdate(ws *websocket.Conn, protocol string) (int, error) {
+	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	sent := 0
+	if protocol == PROTOCOL_BINARY {
+		if update := s.term.UpdateBinary(); update != nil {
+			if err := ws.WriteMessage(websocket.BinaryMessage, update); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		if s.term.TakeTitleUpdate() {
+			var buffer bytes.Buffer
+			s.template.ExecuteTemplate(&buffer, "title_oob", s)
+			if err := ws.WriteMessage(websocket.TextMessage, buffer.Bytes()); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		return sent, nil
+	}
+	if html := s.renderHTML(); len(html) > 0 {
+		if err := ws.WriteMessage(websocket.TextMessage, html); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *Session) wsSender(ws *websocket.Conn, done chan struct{}, acks chan struct{}) {
 	// consumer
 	ticker := time.NewTicker(25 * time.Millisecond)
 	defer ticker.Stop()
-	doSend := false
+	doSend := true // full resync on attach
+	inflight := 0
+	lastFrame := time.Now()
+	budget := FRAME_BUDGET
 	for {
-		// fmt.Println("wsSender!")
+		// intermediate states are never sent, output is only read while there is budget left
+		input := s.new_data
+		if budget <= 0 {
+			input = nil
+		}
 		select {
 		case <-ticker.C:
-			if doSend {
-				s.ws_conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				if err := s.ws_conn.WriteMessage(websocket.TextMessage, s.renderHTML()); err != nil {
+			if inflight >= MAX_INFLIGHT && time.Since(lastFrame) > ACK_TIMEOUT {
+				inflight = 0
+			}
+			if err := s.sendUploads(ws); err != nil {
+				return
+			}
+			if doSend && inflight < MAX_INFLIGHT {
+				bell, protocol := s.settings()
+				sent, err := s.sendUpdate(ws, protocol)
+				if err != nil {
+					return
+				}
+				if sent > 0 {
+					inflight += sent
+					lastFrame = time.Now()
+				}
+				if err := s.sendClipboard(ws); err != nil {
 					return
 				}
+				if s.term.TakeBell() && bell != database.BELL_NONE {
+					if err := sendEvent(ws, ServerEvent{Type: "bell", BellEvent: &BellEvent{Mode: bell}}); err != nil {
+						return
+					}
+				}
 				doSend = false
+				budget = FRAME_BUDGET
+			}
+		case data := <-input:
+			s.term.Write(data)
+			budget -= len(data)
+			doSend = true
+		case <-acks:
+			if inflight > 0 {
+				inflight--
 			}
-		case r := <-s.new_data:
-			s.term.ProcessCharacter(r)
+		case <-s.resync:
+			s.term.Resync()
+			doSend = true
+		case <-s.updated:
 			doSend = true
-		case <-s.ws_done:
+		case <-done:
 			return
 		}
 	}
 }
 
-func (s *Session) wsPinger() {
+func (s *Session) wsPinger(ws *websocket.Conn, done chan struct{}) {
 	ticker := time.NewTicker(54 * time.Second)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			if err := s.ws_conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
+			if err := ws.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
 				log.Println("ping:", err)
 			}
-		case <-s.ws_done:
+		case <-done:
 			return
 		}
 	}
 }
 
+// ServerEvent is a JSON message sent to the browser next to the HTML updates.
+type ServerEvent struct {
+	Type string `json:"type"`
+	*ClipboardEvent
+	*BellEvent
+	*UploadEvent
+}
+
+type BellEvent struct {
+	Mode string `json:"mode"`
+}
+
+type ClipboardEvent struct {
+	Selection string `json:"selection"`
+	Text      string `json:"text,omitempty"`
+	Ask       bool   `json:"ask"`
+}
+
+func sendEvent(ws *websocket.Conn, event ServerEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return ws.WriteMessage(websocket.TextMessage, message)
+}
+
+func (s *Session) sendClipboard(ws *websocket.Conn) error {
+	for _, request := range s.term.TakeClipboard() {
+		if s.Server.Clipboard == database.CLIPBOARD_DENY {
+			fmt.Println("OSC 52 denied for", s.Server.Name)
+			continue
+		}
+		event := ServerEvent{Type: "clipboard", ClipboardEvent: &ClipboardEvent{
+			Selection: request.Selection,
+			Text:      request.Text,
+			Ask:       s.Server.Clipboard != database.CLIPBOARD_ALLOW || request.Query, // allow covers copying only
+		}}
+		if request.Query {
+			event.Type = "clipboard_query"
+		}
+		if err := sendEvent(ws, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type KeyMessage struct {
 	Keys string `json:"keys"`
 }
@@ -232,18 +480,29 @@ type SizeMessage struct {
 	Rows    int `json:"rows"`
 }
 
+type ClipboardMessage struct {
+	Selection string `json:"selection"`
+	Text      string `json:"text"`
+}
+
+type ProtocolMessage struct {
+	Protocol string `json:"protocol"`
+}
+
 type BrowserMessage struct {
 	Type string `json:"type"`
 	*KeyMessage
 	*SizeMessage
+	*ClipboardMessage
+	*ProtocolMessage
 }
 
-func (s *Session) sendStdin() {
-	s.ws_conn.SetReadLimit(8192)
-	s.ws_conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	s.ws_conn.SetPongHandler(func(string) error { s.ws_conn.SetReadDeadline(time.Now().Add(60 * time.Second)); return nil })
+func (s *Session) sendStdin(ws *websocket.Conn, acks chan struct{}) {
+	ws.SetReadLimit(8192)
+	ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+	ws.SetPongHandler(func(string) error { ws.SetReadDeadline(time.Now().Add(60 * time.Second)); return nil })
 	for {
-		_, message, err := s.ws_conn.ReadMessage()
+		_, message, err := ws.ReadMessage()
 		if err != nil {
 			fmt.Println("ReadMessage error:", err)
 			break
@@ -254,29 +513,123 @@ func (s *Session) sendStdin() {
 			continue
 		}
 
-		if msg.Type == "keyboard" {
-			if _, err := s.stdin.Write([]byte(msg.Keys)); err != nil {
+		if msg.Type == "ack" {
+			select {
+			case acks <- struct{}{}:
+			default:
+			}
+		} else if msg.Type == "keyboard" && msg.KeyMessage != nil {
+			s.broadcastStdin([]byte(msg.Keys))
+			if err := s.InjectStdin([]byte(msg.Keys)); err == ErrNotConnected {
+				continue
+			} else if err != nil {
 				fmt.Println("sendStdin Write")
 				break
 			}
 		} else if msg.Type == "size" {
 			s.updateSize(msg.Rows, msg.Columns)
+		} else if msg.Type == "protocol" && msg.ProtocolMessage != nil {
+			if msg.Protocol == PROTOCOL_HTML || msg.Protocol == PROTOCOL_BINARY {
+				s.mu.Lock()
+				s.protocol = msg.Protocol
+				s.mu.Unlock()
+				select {
+				case s.resync <- struct{}{}:
+				default:
+				}
+			}
+		} else if msg.Type == "clipboard" && msg.ClipboardMessage != nil {
+			if s.Server.Clipboard == database.CLIPBOARD_DENY {
+				continue
+			}
+			if err := s.term.ReplyClipboard(msg.Selection, msg.Text); err != nil {
+				fmt.Println("ReplyClipboard error:", err)
+			}
 		}
 	}
-	close(s.ws_done)
 }
 
 func (s *Session) updateSize(rows, cols int) {
-	if s.ssh_session != nil {
-		err := s.ssh_session.WindowChange(rows, cols)
+	if s.player != nil {
+		// playback keeps the recorded size
+		return
+	}
+	s.mu.Lock()
+	ssh_session := s.ssh_session
+	s.mu.Unlock()
+	if ssh_session != nil {
+		err := ssh_session.WindowChange(rows, cols)
 		if err != nil {
 			fmt.Println("WindowChange error:", err)
 		}
 	}
 	s.term.SetSize(rows, cols)
+	if recorder := s.recording(); recorder != nil {
+		recorder.Resize(cols, rows)
+	}
 }
 
 func (s *Session) InjectStdin(bytes []byte) error {
-	_, err := s.stdin.Write(bytes)
+	s.mu.Lock()
+	stdin := s.stdin
+	s.mu.Unlock()
+	if stdin == nil {
+		return ErrNotConnected
+	}
+	if recorder := s.recording(); recorder != nil {
+		recorder.Input(bytes)
+	}
+	s.auditStdin(bytes)
+	n, err := stdin.Write(bytes)
+	s.bytes_in.Add(int64(n))
 	return err
 }
+
+// Paste writes text like a paste in a terminal: line feeds become carriage
+// returns and the text is bracketed when the remote program enabled it.
+func (s *Session) Paste(text string) error {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\r"), "\n", "\r")
+	if s.term.BracketedPaste() {
+		// the end marker can't be pasted, it would end the paste early
+		text = PASTE_START + strings.ReplaceAll(text, PASTE_END, "") + PASTE_END
+	}
+	return s.InjectStdin([]byte(text))
+}
+
+// StartRecording records the output, and the input if enabled, to a new asciicast file in dir.
+func (s *Session) StartRecording(dir string, input bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recorder != nil {
+		return nil
+	}
+	rows, columns := s.term.GetSize()
+	recorder, err := recording.Create(dir, s.Server.Name, columns, rows, input)
+	if err != nil {
+		return err
+	}
+	s.recorder = recorder
+	return nil
+}
+
+func (s *Session) StopRecording() {
+	s.mu.Lock()
+	recorder := s.recorder
+	s.recorder = nil
+	s.mu.Unlock()
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fmt.Println("StopRecording error:", err)
+		}
+	}
+}
+
+func (s *Session) Recording() bool {
+	return s.recording() != nil
+}
+
+func (s *Session) recording() *recording.Recorder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recorder
+}
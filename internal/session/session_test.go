@@ -0,0 +1,338 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"potatossh/internal/database"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testTemplates = `{{ define "title_oob" }}<label id="title_{{ .Id }}">{{ .Terminal.Title }}</label>{{ end }}`
+
+type stdinRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *stdinRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *stdinRecorder) Close() error {
+	return nil
+}
+
+func (r *stdinRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// newTestSession returns a session fed by the returned pipe instead of SSH.
+func newTestSession(t *testing.T) (*Session, *stdinRecorder, *io.PipeWriter) {
+	s, err := NewSession(database.Server{Name: "test"}, template.Must(template.New("test").Parse(testTemplates)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := &stdinRecorder{}
+	s.stdin = stdin
+	s.term.Connected(stdin)
+	reader, writer := io.Pipe()
+	go s.readOutput(reader)
+	return s, stdin, writer
+}
+
+// testClient is a browser, messages can be sent from several goroutines.
+type testClient struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *testClient) send(message map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.WriteJSON(message)
+}
+
+func dial(t *testing.T, server *httptest.Server) *testClient {
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{Conn: ws}
+}
+
+// waitStdin waits until the session wrote want to stdin.
+func waitStdin(t *testing.T, stdin *stdinRecorder, want string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for stdin.String() != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := stdin.String(); got != want {
+		t.Errorf("stdin result: %#q want: %#q", got, want)
+	}
+}
+
+// waitFor reads and acknowledges updates until one contains text.
+func waitFor(t *testing.T, ws *testClient, text string) {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", text, err)
+		}
+		if !bytes.HasPrefix(message, []byte("{")) {
+			ws.send(map[string]any{"type": "ack"})
+		}
+		if strings.Contains(string(message), text) {
+			return
+		}
+	}
+}
+
+func TestConcurrentSession(t *testing.T) {
+	s, stdin, output := newTestSession(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.AttachWebSocket(w, r)
+	}))
+	defer server.Close()
+
+	ws := dial(t, server)
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			io.WriteString(output, "\x1b]0;busy\x07output line\r\n\x07")
+		}
+		io.WriteString(output, "first done\r\n")
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			ws.send(map[string]any{"type": "keyboard", "keys": "a"})
+			ws.send(map[string]any{"type": "size", "rows": 24, "columns": 80})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			s.SetBell(database.BELL_NONE)
+			s.Terminal().Title()
+			s.Terminal().PaletteStyle()
+		}
+	}()
+	waitFor(t, ws, "first done")
+	wg.Wait()
+	waitStdin(t, stdin, strings.Repeat("a", 50))
+
+	// a second browser replaces the first one and gets the whole terminal
+	second := dial(t, server)
+	waitFor(t, second, "first done")
+	io.WriteString(output, "second done\r\n")
+	waitFor(t, second, "second done")
+	second.send(map[string]any{"type": "keyboard", "keys": "b"})
+	waitStdin(t, stdin, strings.Repeat("a", 50)+"b")
+
+	ws.Close()
+	second.Close()
+	output.Close()
+	s.Disconnect()
+}
+
+// floodReader is a program printing output forever.
+type floodReader struct {
+	read atomic.Int64
+}
+
+func (r *floodReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = "flood\r\n"[i%7]
+	}
+	r.read.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestBackpressure(t *testing.T) {
+	s, err := NewSession(database.Server{Name: "test"}, template.Must(template.New("test").Parse(testTemplates)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flood := &floodReader{}
+	go s.readOutput(flood)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.AttachWebSocket(w, r)
+	}))
+	defer server.Close()
+
+	// the browser does not acknowledge any update
+	ws := dial(t, server)
+	time.Sleep(500 * time.Millisecond)
+	read := flood.read.Load()
+	t.Logf("Read %d bytes without acks", read)
+	// processed frames, the chunk overshooting the budget and the chunk waiting in readOutput
+	bound := int64((MAX_INFLIGHT+1)*(FRAME_BUDGET+32*1024) + 32*1024)
+	if read > bound {
+		t.Errorf("Read %d bytes without acks, bound: %d", read, bound)
+	}
+
+	for range 10 {
+		waitFor(t, ws, "")
+	}
+	if flood.read.Load() <= read {
+		t.Errorf("Output is not read after acks")
+	}
+
+	ws.Close()
+	s.Disconnect()
+}
+
+func TestInjectStdinNotConnected(t *testing.T) {
+	s, err := NewSession(database.Server{Name: "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InjectStdin([]byte("ls\n")); err != ErrNotConnected {
+		t.Errorf("InjectStdin error: %v want: %v", err, ErrNotConnected)
+	}
+	s.Disconnect()
+}
+
+// BenchmarkReadOutput measures the hand-off of SSH output to the terminal.
+func BenchmarkReadOutput(b *testing.B) {
+	output := bytes.Repeat([]byte("zażółć gęślą jaźń \x1b[1mbold\x1b[0m plain text\r\n"), 20000)
+	b.SetBytes(int64(len(output)))
+	for range b.N {
+		s, err := NewSession(database.Server{Name: "bench"}, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			s.readOutput(bytes.NewReader(output))
+			close(done)
+		}()
+	consume:
+		for {
+			select {
+			case data := <-s.new_data:
+				s.term.Write(data)
+			case <-done:
+				break consume
+			}
+		}
+	}
+}
+
+func TestPaste(t *testing.T) {
+	s, stdin, writer := newTestSession(t)
+	defer writer.Close()
+
+	s.Paste("echo a\necho b\n")
+	if stdin.String() != "echo a\recho b\r" {
+		t.Errorf("Paste: %q", stdin.String())
+	}
+
+	// the remote program enables bracketed paste
+	s.term.Write([]byte("\x1b[?2004h"))
+	s.Paste("ls\x1b[201~\n")
+	if want := "echo a\recho b\r\x1b[200~ls\r\x1b[201~"; stdin.String() != want {
+		t.Errorf("Bracketed paste: %q, want %q", stdin.String(), want)
+	}
+}
+
+func TestDisconnectWithoutBrowser(t *testing.T) {
+	s, err := NewSession(database.Server{Name: "test"}, template.Must(template.New("test").Parse(testTemplates)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	returned := make(chan struct{})
+	go func() {
+		s.readOutput(reader)
+		close(returned)
+	}()
+	// nobody consumes this output
+	io.WriteString(writer, "output\r\n")
+	s.Disconnect()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readOutput blocked after Disconnect")
+	}
+}
+
+func TestClipboardAllow(t *testing.T) {
+	s, _, output := newTestSession(t)
+	s.Server.Clipboard = database.CLIPBOARD_ALLOW
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.AttachWebSocket(w, r)
+	}))
+	defer server.Close()
+	ws := dial(t, server)
+	defer ws.Close()
+
+	io.WriteString(output, "\x1b]52;c;aGk=\x07\x1b]52;c;?\x07")
+	want := map[string]bool{"clipboard": false, "clipboard_query": true}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(want) > 0 {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(message, []byte("{")) {
+			ws.send(map[string]any{"type": "ack"})
+			continue
+		}
+		var event struct {
+			Type string `json:"type"`
+			Ask  bool   `json:"ask"`
+		}
+		json.Unmarshal(message, &event)
+		if ask, ok := want[event.Type]; ok {
+			if event.Ask != ask {
+				t.Errorf("%s ask: %v want: %v", event.Type, event.Ask, ask)
+			}
+			delete(want, event.Type)
+		}
+	}
+	output.Close()
+	s.Disconnect()
+}
+
+// TestHostileTitle renders the tab label of the real template after the remote
+// reported a working directory and a title crafted to inject markup.
+func TestHostileTitle(t *testing.T) {
+	tmpl := template.Must(template.New("index.html").Funcs(template.FuncMap{
+		"openInNewWindowEnabled": func() bool { return false },
+		"speeds":                 func() []float64 { return nil },
+	}).ParseFiles("../../web/templates/index.html"))
+	s, err := NewSession(database.Server{Name: "test"}, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.term.Write([]byte("\x1b]7;file://h/a%22%3E%3Cimg src=x onerror=alert(1)%3E\x07"))
+	s.term.Write([]byte("\x1b]7;file://h/a\\\"><img src=x>\x07"))
+	s.term.Write([]byte("\x1b]2;\"><img src=x onerror=alert(2)>\x07"))
+
+	var buffer bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buffer, "title_oob", s); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buffer.String(), "<img") {
+		t.Errorf("markup injected: %s", buffer.String())
+	}
+}
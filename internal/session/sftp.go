@@ -0,0 +1,221 @@
+package session
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+)
+
+// FileInfo describes a remote file, Path is absolute.
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	Dir     bool      `json:"dir"`
+}
+
+func newFileInfo(dir string, info os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    info.Name(),
+		Path:    path.Join(dir, info.Name()),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+		Dir:     info.IsDir(),
+	}
+}
+
+// SFTP returns the SFTP client of the session, started on the authenticated
+// SSH connection on the first use.
+func (s *Session) SFTP() (*sftp.Client, error) {
+	s.mu.Lock()
+	sftp_client, ssh_client := s.sftp_client, s.ssh_client
+	s.mu.Unlock()
+	if sftp_client != nil {
+		return sftp_client, nil
+	}
+	if ssh_client == nil {
+		return nil, ErrNotConnected
+	}
+	// starting the subsystem waits on the network, without the lock
+	client, err := sftp.NewClient(ssh_client)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sftp_client != nil {
+		// started by another call meanwhile
+		client.Close()
+		return s.sftp_client, nil
+	}
+	select {
+	case <-s.done:
+		// Disconnect didn't see this client
+		client.Close()
+		return nil, ErrNotConnected
+	default:
+	}
+	s.sftp_client = client
+	return client, nil
+}
+
+// ResolvePath returns the absolute remote path. An empty path is the shell's
+// current directory when reported with OSC 7, else the home directory.
+func (s *Session) ResolvePath(p string) (string, error) {
+	if len(p) == 0 {
+		p = s.term.Cwd()
+	}
+	if path.IsAbs(p) {
+		return path.Clean(p), nil
+	}
+	client, err := s.SFTP()
+	if err != nil {
+		return "", err
+	}
+	home, err := client.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(home, p), nil
+}
+
+// ListDir returns the directories followed by the files, sorted by name.
+func (s *Session) ListDir(dir string) ([]FileInfo, error) {
+	client, err := s.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(infos))
+	for _, info := range infos {
+		files = append(files, newFileInfo(dir, info))
+	}
+	slices.SortFunc(files, func(a, b FileInfo) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return files, nil
+}
+
+func (s *Session) Stat(p string) (FileInfo, error) {
+	client, err := s.SFTP()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := client.Stat(p)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return newFileInfo(path.Dir(p), info), nil
+}
+
+// Download streams the remote file to w, size is called before the first write.
+func (s *Session) Download(p string, w io.Writer, size func(int64)) error {
+	client, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+	file, err := client.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size(info.Size())
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Upload streams r to a temporary file next to p, renamed to p once complete:
+// a failed or canceled upload leaves an existing file intact.
+func (s *Session) Upload(p string, r io.Reader) error {
+	client, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(p), fmt.Sprintf(".%s.%s.part", path.Base(p), uuid.New().String()[:8]))
+	file, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if info, statErr := client.Stat(p); statErr == nil {
+			client.Chmod(tmp, info.Mode().Perm()) // an overwritten file keeps its permissions
+		}
+		err = replaceFile(client, tmp, p)
+	}
+	if err != nil {
+		client.Remove(tmp)
+	}
+	return err
+}
+
+// replaceFile renames from over to, atomically when the server supports POSIX renames.
+func replaceFile(client *sftp.Client, from, to string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(from, to)
+	}
+	if err := client.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+func (s *Session) Rename(from, to string) error {
+	client, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+// Delete removes a file or a directory with its content.
+func (s *Session) Delete(p string) error {
+	client, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+	info, err := client.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return client.RemoveAll(p)
+	}
+	return client.Remove(p)
+}
+
+func (s *Session) Mkdir(p string) error {
+	client, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+	return client.Mkdir(p)
+}
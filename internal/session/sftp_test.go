@@ -0,0 +1,111 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestSFTP connects the session to an in-memory SFTP server.
+func newTestSFTP(t *testing.T, s *Session) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, sftp.InMemHandler())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sftp_client = client
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+}
+
+func TestFiles(t *testing.T) {
+	s, _, writer := newTestSession(t)
+	defer writer.Close()
+	newTestSFTP(t, s)
+
+	if err := s.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload("/b.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload("/A.txt", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.ListDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Path)
+	}
+	if strings.Join(names, " ") != "/dir /A.txt /b.txt" || !files[0].Dir || files[2].Size != 5 {
+		t.Errorf("Listed files: %v", files)
+	}
+
+	if err := s.Rename("/b.txt", "/dir/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	size := int64(-1)
+	if err := s.Download("/dir/c.txt", &buffer, func(n int64) { size = n }); err != nil || buffer.String() != "hello" || size != 5 {
+		t.Errorf("Downloaded: %#q size: %d error: %v", buffer.String(), size, err)
+	}
+
+	if err := s.Delete("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("/dir/c.txt"); err == nil {
+		t.Errorf("Deleted file still exists")
+	}
+	if path, err := s.ResolvePath("/dir/../x"); err != nil || path != "/x" {
+		t.Errorf("Resolved path: %#q error: %v", path, err)
+	}
+}
+
+// failingReader stops like a canceled upload.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestUploadCanceled(t *testing.T) {
+	s, _, writer := newTestSession(t)
+	defer writer.Close()
+	newTestSFTP(t, s)
+
+	if err := s.Upload("/partial", io.MultiReader(strings.NewReader("abc"), failingReader{})); err == nil {
+		t.Errorf("Upload succeeded")
+	}
+	if _, err := s.Stat("/partial"); err == nil {
+		t.Errorf("Partial upload not removed")
+	}
+
+	// a failed overwrite keeps the existing file and leaves no temporary file
+	if err := s.Upload("/keep.txt", strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload("/keep.txt", io.MultiReader(strings.NewReader("ab"), failingReader{})); err == nil {
+		t.Errorf("Upload succeeded")
+	}
+	var content bytes.Buffer
+	if err := s.Download("/keep.txt", &content, func(int64) {}); err != nil || content.String() != "kept" {
+		t.Errorf("Existing file after a failed upload: %q %v", content.String(), err)
+	}
+	if files, err := s.ListDir("/"); err != nil || len(files) != 1 {
+		t.Errorf("Files after a failed upload: %v %v", files, err)
+	}
+}
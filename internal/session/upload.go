@@ -0,0 +1,327 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// What to do when a dropped file already exists
+const (
+	CONFLICT_OVERWRITE = "overwrite"
+	CONFLICT_RENAME    = "rename" // upload as "name (1).ext"
+	CONFLICT_SKIP      = "skip"
+)
+
+// UPLOAD_PROGRESS_INTERVAL is the minimal time between progress events of an upload.
+const UPLOAD_PROGRESS_INTERVAL = 200 * time.Millisecond
+
+var ErrFileExists = errors.New("file exists")
+
+// Upload is a file dropped into the session, its progress is sent to the browser.
+type Upload struct {
+	Id       string
+	Name     string
+	Size     int64
+	sent     atomic.Int64
+	lastSent int64 // sent in the last event
+	lastTime time.Time
+	done     bool
+	err      error
+}
+
+type UploadEvent struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Sent  int64  `json:"sent"`
+	Size  int64  `json:"size"`
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+type progressReader struct {
+	io.Reader
+	upload *Upload
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.upload.sent.Add(int64(n))
+	return n, err
+}
+
+// remoteFiles writes files to the server over SFTP or, without it, scp.
+type remoteFiles interface {
+	exists(p string) (bool, error)
+	write(p string, size int64, r io.Reader) error
+}
+
+type sftpFiles struct {
+	session *Session
+	client  *sftp.Client
+}
+
+func (f sftpFiles) exists(p string) (bool, error) {
+	_, err := f.client.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f sftpFiles) write(p string, size int64, r io.Reader) error {
+	return f.session.Upload(p, r)
+}
+
+type scpFiles struct {
+	client *ssh.Client
+}
+
+func (f scpFiles) exists(p string) (bool, error) {
+	session, err := f.client.NewSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+	err = session.Run("test -e " + shellQuote(p))
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && exit.ExitStatus() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// write sends the file under a temporary name, moved to p once complete like
+// Session.Upload does.
+func (f scpFiles) write(p string, size int64, r io.Reader) error {
+	tmp := path.Join(path.Dir(p), fmt.Sprintf(".%s.%s.part", path.Base(p), uuid.New().String()[:8]))
+	err := f.send(tmp, size, r)
+	if err == nil {
+		err = f.run("mv -f " + shellQuote(tmp) + " " + shellQuote(p))
+	}
+	if err != nil {
+		f.run("rm -f " + shellQuote(tmp))
+	}
+	return err
+}
+
+func (f scpFiles) run(command string) error {
+	session, err := f.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(command)
+}
+
+func (f scpFiles) send(p string, size int64, r io.Reader) error {
+	session, err := f.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("scp -t " + shellQuote(path.Dir(p))); err != nil {
+		return err
+	}
+	err = scpSend(stdin, stdout, path.Base(p), size, r)
+	stdin.Close()
+	if waitErr := session.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+// scpSend sends one file to "scp -t": a C record, the content and a zero byte,
+// each acknowledged by the remote side.
+func scpSend(stdin io.Writer, stdout io.Reader, name string, size int64, r io.Reader) error {
+	acks := bufio.NewReader(stdout)
+	if err := scpAck(acks); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C0644 %d %s\n", size, name); err != nil {
+		return err
+	}
+	if err := scpAck(acks); err != nil {
+		return err
+	}
+	n, err := io.Copy(stdin, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return io.ErrUnexpectedEOF
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	return scpAck(acks)
+}
+
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	message, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(message))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteFiles returns SFTP when the server supports it, else scp.
+func (s *Session) remoteFiles() (remoteFiles, error) {
+	client, err := s.SFTP()
+	if err == nil {
+		return sftpFiles{session: s, client: client}, nil
+	}
+	if errors.Is(err, ErrNotConnected) {
+		return nil, err
+	}
+	fmt.Println("SFTP unavailable, using scp:", err)
+	s.mu.Lock()
+	ssh_client := s.ssh_client
+	s.mu.Unlock()
+	return scpFiles{client: ssh_client}, nil
+}
+
+// UploadDir returns the directory where dropped files are written: the shell's
+// current directory when known, else the home directory.
+func (s *Session) UploadDir() string {
+	if cwd := s.term.Cwd(); len(cwd) > 0 {
+		return cwd
+	}
+	if dir, err := s.ResolvePath("."); err == nil {
+		return dir
+	}
+	return "." // relative to the home directory for scp
+}
+
+// Existing returns the names which already exist in dir.
+func (s *Session) Existing(dir string, names []string) ([]string, error) {
+	files, err := s.remoteFiles()
+	if err != nil {
+		return nil, err
+	}
+	existing := []string{}
+	for _, name := range names {
+		ok, err := files.exists(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			existing = append(existing, name)
+		}
+	}
+	return existing, nil
+}
+
+// DropFile writes r to dir/name, reporting the progress with upload id to the
+// browser. It returns the written path, or ErrFileExists when skipped.
+func (s *Session) DropFile(id, dir, name string, size int64, conflict string, r io.Reader) (string, error) {
+	upload := &Upload{Id: id, Name: name, Size: size}
+	s.mu.Lock()
+	s.uploads = append(s.uploads, upload)
+	s.mu.Unlock()
+
+	p, err := s.dropFile(dir, name, size, conflict, progressReader{r, upload})
+	s.mu.Lock()
+	upload.done, upload.err = true, err
+	s.mu.Unlock()
+	return p, err
+}
+
+func (s *Session) dropFile(dir, name string, size int64, conflict string, r io.Reader) (string, error) {
+	files, err := s.remoteFiles()
+	if err != nil {
+		return "", err
+	}
+	return writeFile(files, dir, name, size, conflict, r)
+}
+
+// UploadFile writes r to dir/name over SFTP, for the file browser. A conflict
+// is resolved like DropFile does.
+func (s *Session) UploadFile(dir, name, conflict string, r io.Reader) (string, error) {
+	client, err := s.SFTP()
+	if err != nil {
+		return "", err
+	}
+	return writeFile(sftpFiles{session: s, client: client}, dir, name, 0, conflict, r)
+}
+
+func writeFile(files remoteFiles, dir, name string, size int64, conflict string, r io.Reader) (string, error) {
+	p := path.Join(dir, name)
+	if conflict != CONFLICT_OVERWRITE {
+		exists, err := files.exists(p)
+		if err != nil {
+			return "", err
+		}
+		if exists && conflict == CONFLICT_SKIP {
+			return "", ErrFileExists
+		}
+		for i := 1; exists; i++ {
+			if i > 100 {
+				return "", ErrFileExists
+			}
+			ext := path.Ext(name)
+			p = path.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext))
+			if exists, err = files.exists(p); err != nil {
+				return "", err
+			}
+		}
+	}
+	return p, files.write(p, size, r)
+}
+
+// sendUploads sends the progress of the uploads, finished ones are sent once and removed.
+func (s *Session) sendUploads(ws *websocket.Conn) error {
+	events := []UploadEvent{}
+	s.mu.Lock()
+	uploads := s.uploads[:0]
+	for _, upload := range s.uploads {
+		sent := upload.sent.Load()
+		if upload.done || sent != upload.lastSent && time.Since(upload.lastTime) >= UPLOAD_PROGRESS_INTERVAL {
+			event := UploadEvent{Id: upload.Id, Name: upload.Name, Sent: sent, Size: upload.Size, Done: upload.done}
+			if upload.err != nil {
+				event.Error = upload.err.Error()
+			}
+			events = append(events, event)
+			upload.lastSent, upload.lastTime = sent, time.Now()
+		}
+		if !upload.done {
+			uploads = append(uploads, upload)
+		}
+	}
+	s.uploads = uploads
+	s.mu.Unlock()
+
+	for i := range events {
+		if err := sendEvent(ws, ServerEvent{Type: "upload", UploadEvent: &events[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScpSend(t *testing.T) {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	received := make(chan string, 1)
+	// the remote "scp -t" acknowledges the start, the record and the content
+	go func() {
+		r := bufio.NewReader(stdinReader)
+		stdoutWriter.Write([]byte{0})
+		record, _ := r.ReadString('\n')
+		stdoutWriter.Write([]byte{0})
+		content := make([]byte, 6)
+		io.ReadFull(r, content)
+		stdoutWriter.Write([]byte{0})
+		received <- record + string(content)
+	}()
+	if err := scpSend(stdinWriter, stdoutReader, "a.txt", 5, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "C0644 5 a.txt\nhello\x00" {
+		t.Errorf("Received %q", got)
+	}
+
+	remote := bytes.NewReader([]byte("\x01scp: /dir: Permission denied\n"))
+	err := scpSend(io.Discard, remote, "a.txt", 5, strings.NewReader("hello"))
+	if err == nil || err.Error() != "scp: scp: /dir: Permission denied" {
+		t.Errorf("Error: %v", err)
+	}
+}
+
+func TestDropFile(t *testing.T) {
+	s, _, writer := newTestSession(t)
+	defer writer.Close()
+	newTestSFTP(t, s)
+
+	if err := s.Upload("/a.txt", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	existing, err := s.Existing("/", []string{"a.txt", "b.txt"})
+	if err != nil || len(existing) != 1 || existing[0] != "a.txt" {
+		t.Errorf("Existing: %v %v", existing, err)
+	}
+
+	if _, err := s.DropFile("1", "/", "a.txt", 3, CONFLICT_SKIP, strings.NewReader("new")); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Skip: %v", err)
+	}
+	for _, want := range []string{"/a (1).txt", "/a (2).txt"} {
+		p, err := s.DropFile("2", "/", "a.txt", 3, CONFLICT_RENAME, strings.NewReader("new"))
+		if err != nil || p != want {
+			t.Errorf("Rename: %q %v, want %q", p, err, want)
+		}
+	}
+	p, err := s.DropFile("3", "/", "a.txt", 3, CONFLICT_OVERWRITE, strings.NewReader("new"))
+	if err != nil || p != "/a.txt" {
+		t.Errorf("Overwrite: %q %v", p, err)
+	}
+	var content bytes.Buffer
+	if err := s.Download("/a.txt", &content, func(int64) {}); err != nil || content.String() != "new" {
+		t.Errorf("Content: %q %v", content.String(), err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.uploads) != 4 || !s.uploads[3].done || s.uploads[3].sent.Load() != 3 || s.uploads[0].err == nil {
+		t.Errorf("Uploads: %v", s.uploads)
+	}
+}
+
+func TestDropFileCanceled(t *testing.T) {
+	s, _, writer := newTestSession(t)
+	defer writer.Close()
+	newTestSFTP(t, s)
+
+	if err := s.Upload("/keep.txt", strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+	// the browser cancels the upload after 2 bytes
+	if _, err := s.DropFile("1", "/", "keep.txt", 4, CONFLICT_OVERWRITE, io.MultiReader(strings.NewReader("ab"), failingReader{})); err == nil {
+		t.Errorf("Canceled upload succeeded")
+	}
+	var content bytes.Buffer
+	if err := s.Download("/keep.txt", &content, func(int64) {}); err != nil || content.String() != "kept" {
+		t.Errorf("Overwritten file after a canceled upload: %q %v", content.String(), err)
+	}
+}
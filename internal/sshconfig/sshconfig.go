@@ -0,0 +1,380 @@
+// Package sshconfig reads OpenSSH client configuration files into servers and
+// writes servers back in the same format.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"potatossh/internal/database"
+	"potatossh/internal/session"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MAX_INCLUDE_DEPTH limits nested Include directives, like OpenSSH.
+const MAX_INCLUDE_DEPTH = 16
+
+type option struct {
+	keyword string // lower case
+	args    []string
+}
+
+// block holds the options of a Host section, the options before the first one
+// apply to all hosts. Match sections are kept without patterns, so they never apply.
+type block struct {
+	patterns []string
+	options  []option
+}
+
+// Config is a parsed ssh_config with its Include directives inlined.
+type Config struct {
+	blocks []*block
+	root   string // Include only reads files below it, empty for no limit
+}
+
+// ParseFile parses the file, relative Include paths are resolved from ~/.ssh.
+func ParseFile(name string) (*Config, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parse(file, filepath.Join(home, ".ssh"), "")
+}
+
+// Parse parses a configuration sent by a browser, relative Include paths are
+// resolved from dir and only files below it are included.
+func Parse(r io.Reader, dir string) (*Config, error) {
+	return parse(r, dir, dir)
+}
+
+func parse(r io.Reader, dir, root string) (*Config, error) {
+	c := &Config{blocks: []*block{{patterns: []string{"*"}}}, root: root}
+	if err := c.parse(r, dir, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) parse(r io.Reader, dir string, depth int) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args := splitLine(scanner.Text())
+		if len(keyword) == 0 {
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("line %d: %s without a value", line, keyword)
+		}
+		switch keyword {
+		case "host":
+			c.blocks = append(c.blocks, &block{patterns: args})
+		case "match":
+			c.blocks = append(c.blocks, &block{})
+		case "include":
+			if depth >= MAX_INCLUDE_DEPTH {
+				return fmt.Errorf("line %d: too many nested includes", line)
+			}
+			for _, pattern := range args {
+				c.include(pattern, dir, depth+1)
+			}
+		default:
+			current := c.blocks[len(c.blocks)-1]
+			current.options = append(current.options, option{keyword: keyword, args: args})
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files matching the pattern in the current block, like
+// OpenSSH. Files which can't be read or parsed and files outside of the root
+// are skipped, their content isn't reported.
+func (c *Config) include(pattern, dir string, depth int) {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	names, _ := filepath.Glob(pattern)
+	for _, name := range names {
+		if !c.allowed(name) {
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		blocks, options := len(c.blocks), len(c.blocks[len(c.blocks)-1].options)
+		err = c.parse(file, dir, depth)
+		file.Close()
+		if err != nil {
+			// drop what the file added
+			c.blocks = c.blocks[:blocks]
+			current := c.blocks[blocks-1]
+			current.options = current.options[:options]
+		}
+	}
+}
+
+// allowed tells if the file is below the root, after following symbolic links.
+func (c *Config) allowed(name string) bool {
+	if len(c.root) == 0 {
+		return true
+	}
+	root, err := filepath.EvalSymlinks(c.root)
+	if err != nil {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, real)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// splitLine returns the lower case keyword and the arguments, "keyword=value" is allowed.
+func splitLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	return keyword, splitArgs(rest)
+}
+
+// splitArgs splits on spaces outside of double quotes, a # starts a comment.
+func splitArgs(s string) []string {
+	args := []string{}
+	var arg strings.Builder
+	quoted, started := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted, started = !quoted, true
+		case (c == ' ' || c == '\t') && !quoted:
+			if started {
+				args = append(args, arg.String())
+				arg.Reset()
+				started = false
+			}
+		case c == '#' && !quoted && !started:
+			return args
+		default:
+			arg.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// match tells if the host matches the patterns: one of them has to match and
+// none of the negated ones.
+func match(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(host))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok && !negated
+	}
+	return matched
+}
+
+// Hosts returns the aliases without wildcards, in the order of the file.
+func (c *Config) Hosts() []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, b := range c.blocks[1:] {
+		for _, pattern := range b.patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			hosts = append(hosts, pattern)
+		}
+	}
+	return hosts
+}
+
+// All returns the values of the keyword for the host, in the order of the
+// matching blocks. For most keywords only the first one is used.
+func (c *Config) All(host, keyword string) [][]string {
+	values := [][]string{}
+	for _, b := range c.blocks {
+		if !match(b.patterns, host) {
+			continue
+		}
+		for _, o := range b.options {
+			if o.keyword == keyword {
+				values = append(values, o.args)
+			}
+		}
+	}
+	return values
+}
+
+// Get returns the first value of the keyword for the host.
+func (c *Config) Get(host, keyword string) string {
+	if values := c.All(host, keyword); len(values) > 0 {
+		return values[0][0]
+	}
+	return ""
+}
+
+// Servers returns a server for each alias. A missing user is the local one,
+// jump hosts are resolved to "user@hostname:port" when they are aliases.
+func (c *Config) Servers() []database.Server {
+	servers := []database.Server{}
+	for _, host := range c.Hosts() {
+		server := database.Server{Name: host, Port: 22}
+		server.Address, server.Port, server.User = c.resolve(host)
+		if identity := c.Get(host, "identityfile"); len(identity) > 0 && !strings.EqualFold(identity, "none") {
+			server.IdentityFile = identity
+		}
+		server.ProxyJump = c.resolveJumps(c.Get(host, "proxyjump"))
+		server.Forwards = strings.Join(c.forwards(host), "\n")
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// resolve returns the address, port and user of a host.
+func (c *Config) resolve(host string) (string, uint16, string) {
+	address := host
+	if hostname := c.Get(host, "hostname"); len(hostname) > 0 {
+		address = strings.ReplaceAll(strings.ReplaceAll(hostname, "%h", host), "%%", "%")
+	}
+	port := uint16(22)
+	if p, err := strconv.ParseUint(c.Get(host, "port"), 10, 16); err == nil && p > 0 {
+		port = uint16(p)
+	}
+	name := c.Get(host, "user")
+	if len(name) == 0 {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+	return address, port, name
+}
+
+func (c *Config) resolveJumps(jumps string) string {
+	if len(jumps) == 0 || strings.EqualFold(jumps, "none") {
+		return ""
+	}
+	resolved := []string{}
+	for _, jump := range strings.Split(jumps, ",") {
+		name, host := "", jump
+		if at := strings.LastIndex(jump, "@"); at >= 0 {
+			name, host = jump[:at], jump[at+1:]
+		}
+		if len(c.Get(host, "hostname")) > 0 {
+			address, port, user := c.resolve(host)
+			if len(name) == 0 {
+				name = user
+			}
+			host = net.JoinHostPort(address, strconv.Itoa(int(port)))
+		}
+		if len(name) > 0 {
+			host = name + "@" + host
+		}
+		resolved = append(resolved, host)
+	}
+	return strings.Join(resolved, ",")
+}
+
+// forwards converts the forwarding options to the session.Forward syntax.
+func (c *Config) forwards(host string) []string {
+	forwards := []string{}
+	for _, option := range []struct{ keyword, kind string }{
+		{"localforward", session.FORWARD_LOCAL},
+		{"remoteforward", session.FORWARD_REMOTE},
+		{"dynamicforward", session.FORWARD_DYNAMIC},
+	} {
+		for _, args := range c.All(host, option.keyword) {
+			forwards = append(forwards, "-"+option.kind+" "+strings.Join(args, ":"))
+		}
+	}
+	return forwards
+}
+
+// hostName returns a Host pattern for the server name, without spaces and wildcards.
+func hostName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("*?!,\"#", r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Write writes a Host section for each server. Passwords aren't exported,
+// ssh_config has no place for them. Servers with values which would add
+// options are refused before anything is written.
+func Write(w io.Writer, servers []database.Server) error {
+	for _, server := range servers {
+		if err := server.CheckConnection(); err != nil {
+			return fmt.Errorf("%s: %w", server.Name, err)
+		}
+	}
+	writer := bufio.NewWriter(w)
+	fmt.Fprintln(writer, "# Servers exported from PotatoSSH")
+	for _, server := range servers {
+		fmt.Fprintf(writer, "\nHost %s\n", hostName(server.Name))
+		fmt.Fprintf(writer, "    HostName %s\n", server.Address)
+		if server.Port != 22 {
+			fmt.Fprintf(writer, "    Port %d\n", server.Port)
+		}
+		if len(server.User) > 0 {
+			fmt.Fprintf(writer, "    User %s\n", server.User)
+		}
+		if len(server.IdentityFile) > 0 {
+			fmt.Fprintf(writer, "    IdentityFile \"%s\"\n", server.IdentityFile)
+		}
+		if len(server.ProxyJump) > 0 {
+			fmt.Fprintf(writer, "    ProxyJump %s\n", server.ProxyJump)
+		}
+		forwards, _ := session.ParseForwards(server.Forwards)
+		for _, f := range forwards {
+			switch f.Kind {
+			case session.FORWARD_LOCAL:
+				fmt.Fprintf(writer, "    LocalForward %s %s\n", f.Listen, f.Target)
+			case session.FORWARD_REMOTE:
+				fmt.Fprintf(writer, "    RemoteForward %s %s\n", f.Listen, f.Target)
+			case session.FORWARD_DYNAMIC:
+				fmt.Fprintf(writer, "    DynamicForward %s\n", f.Listen)
+			}
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,158 @@
+package sshconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"potatossh/internal/database"
+	"strings"
+	"testing"
+)
+
+const CONFIG = `# global options come first
+Port 2200
+
+Host web web2
+    HostName %h.example.com
+    User deploy
+    IdentityFile ~/.ssh/id_web
+    LocalForward 8080 localhost:80
+    DynamicForward 1080
+
+Host bastion
+    HostName=bastion.example.com
+    Port 22
+    User "jump user"
+
+Host db
+    HostName 10.0.0.5
+    ProxyJump bastion
+    RemoteForward 9000 localhost:3000
+
+Host *.internal !skip.internal
+    User internal
+
+Match exec "true"
+    User ignored
+
+Include conf.d/*.conf
+`
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0700)
+	os.WriteFile(filepath.Join(dir, "conf.d", "extra.conf"), []byte("Host extra\n  HostName extra.example.com\n  User me\n"), 0600)
+
+	config, err := Parse(strings.NewReader(CONFIG), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := strings.Join(config.Hosts(), " "); hosts != "web web2 bastion db extra" {
+		t.Errorf("hosts: %q", hosts)
+	}
+	if user := config.Get("a.internal", "user"); user != "internal" {
+		t.Errorf("wildcard: %q", user)
+	}
+	if user := config.Get("skip.internal", "user"); user != "" {
+		t.Errorf("negated: %q", user)
+	}
+
+	servers := map[string]database.Server{}
+	for _, server := range config.Servers() {
+		servers[server.Name] = server
+	}
+	web2 := servers["web2"]
+	if web2.Address != "web2.example.com" || web2.Port != 2200 || web2.User != "deploy" || web2.IdentityFile != "~/.ssh/id_web" {
+		t.Errorf("web2: %+v", web2)
+	}
+	if web2.Forwards != "-L 8080:localhost:80\n-D 1080" {
+		t.Errorf("web2 forwards: %q", web2.Forwards)
+	}
+	if bastion := servers["bastion"]; bastion.Port != 2200 || bastion.User != "jump user" {
+		t.Errorf("global options come first: %+v", bastion)
+	}
+	if db := servers["db"]; db.ProxyJump != "jump user@bastion.example.com:2200" || db.Forwards != "-R 9000:localhost:3000" {
+		t.Errorf("db: %+v", db)
+	}
+	if extra := servers["extra"]; extra.Address != "extra.example.com" || extra.User != "me" {
+		t.Errorf("include: %+v", extra)
+	}
+}
+
+func TestIncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "loop"), []byte("Host loop\nInclude loop\n"), 0600)
+	config, err := Parse(strings.NewReader("Include loop\n"), dir)
+	// the include too deep is skipped
+	if err != nil || len(config.blocks) != MAX_INCLUDE_DEPTH {
+		t.Errorf("recursive include: %d blocks %v", len(config.blocks), err)
+	}
+}
+
+func TestIncludeOutside(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("Host outside\n  HostName outside.example.com\n"), 0600)
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "link"))
+	os.WriteFile(filepath.Join(dir, "broken"), []byte("Host broken\nroot:x:0:0:root:/root:/bin/bash\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "good"), []byte("Host good\n"), 0600)
+
+	config, err := Parse(strings.NewReader("Include "+filepath.Join(outside, "secret")+" link broken good missing\n"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := strings.Join(config.Hosts(), " "); hosts != "good" {
+		t.Errorf("hosts: %q", hosts)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	servers := []database.Server{
+		{Name: "my web", Address: "web.example.com", Port: 22, User: "deploy", Password: "secret",
+			IdentityFile: "~/.ssh/id_web", Forwards: "-L 8080:localhost:80\n-D 1080"},
+		{Name: "db", Address: "10.0.0.5", Port: 2222, User: "root", ProxyJump: "admin@bastion:22"},
+	}
+	var out bytes.Buffer
+	if err := Write(&out, servers); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Error("password exported")
+	}
+
+	config, err := Parse(&out, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := config.Servers()
+	if len(parsed) != 2 {
+		t.Fatalf("parsed %d servers:\n%s", len(parsed), out.String())
+	}
+	web := parsed[0]
+	if web.Name != "my_web" || web.Address != "web.example.com" || web.IdentityFile != "~/.ssh/id_web" ||
+		web.Forwards != "-L localhost:8080:localhost:80\n-D localhost:1080" {
+		t.Errorf("web: %+v", web)
+	}
+	if db := parsed[1]; db.Port != 2222 || db.User != "root" || db.ProxyJump != "admin@bastion:22" {
+		t.Errorf("db: %+v", db)
+	}
+}
+
+func TestWriteInjection(t *testing.T) {
+	for _, server := range []database.Server{
+		{Name: "web", Address: "web.example.com\nProxyCommand touch /tmp/x", Port: 22},
+		{Name: "web", Address: "web.example.com", Port: 22, User: "me ProxyCommand=x"},
+		{Name: "web", Address: "web.example.com", Port: 22, ProxyJump: "a\tb"},
+		{Name: "web", Address: "web.example.com", Port: 22, IdentityFile: "~/id\" ProxyCommand=x"},
+	} {
+		var out bytes.Buffer
+		if err := Write(&out, []database.Server{server}); err == nil || out.Len() > 0 {
+			t.Errorf("%+v: exported %q", server, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	Write(&out, []database.Server{{Name: "a\nProxyCommand x", Address: "a", Port: 22}})
+	if strings.Contains(out.String(), "\nProxyCommand") {
+		t.Errorf("name injected an option: %q", out.String())
+	}
+}
@@ -0,0 +1,155 @@
+package terminal
+
+import (
+	"encoding/binary"
+)
+
+/*
+Binary cell-grid protocol, an alternative to the HTML updates rendered by the browser.
+All numbers are unsigned varints, strings are a length followed by UTF-8 bytes.
+
+	header:  'P' version flags (single bytes)
+	style:   css (only with BINARY_FULL, inline style of the code element)
+	cursor:  row_id x
+	styles:  count { style_id classes css href link_id }
+	removed: count { row_id }
+	rows:    count { row_id row_flags runs_count { style_id text } }
+
+Styles are sent once and referenced by id afterwards, id 0 is the default style.
+With BINARY_FULL the browser drops all rows and styles before applying the message.
+Rows already shown are replaced, new rows are appended at the bottom.
+*/
+
+const BINARY_VERSION = 1
+
+// header flags
+const (
+	BINARY_FULL = 1 << iota
+	BINARY_CONNECTED
+	BINARY_CURSOR_HIDDEN
+)
+
+// MAX_BINARY_STYLES styles are kept before a full update restarts the table
+// with the styles on the screen.
+const MAX_BINARY_STYLES = 4096
+
+// row flags
+const (
+	BINARY_ROW_PROMPT = 1 << iota
+)
+
+type styleKey struct {
+	classes string
+	css     string
+	href    string
+	linkId  string
+}
+
+// styleTable assigns ids to the styles used by the binary protocol.
+type styleTable struct {
+	ids  map[styleKey]uint64
+	keys []styleKey
+	sent int
+}
+
+func (st *styleTable) id(s *Style) uint64 {
+	key := styleKey{classes: s.Classes(), css: s.Css()}
+	if s.link != nil {
+		key.href, _ = s.link.Href()
+		key.linkId = s.link.id
+	}
+	if key == (styleKey{}) {
+		return 0
+	}
+	if st.ids == nil {
+		st.ids = map[styleKey]uint64{}
+	}
+	id, ok := st.ids[key]
+	if !ok {
+		st.keys = append(st.keys, key)
+		id = uint64(len(st.keys))
+		st.ids[key] = id
+	}
+	return id
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) uint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// UpdateBinary is Update for the binary protocol. It returns nil when nothing changed.
+func (t *Terminal) UpdateBinary() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	styles := &t.render.styles
+	if len(styles.keys) > MAX_BINARY_STYLES {
+		t.render.synced = false
+	}
+	diff := t.diff()
+	if !diff.full && len(diff.removed) == 0 && len(diff.changed) == 0 && len(diff.appended) == 0 {
+		return nil
+	}
+	if diff.full {
+		// the browser drops its styles, only the ones of the rows sent are needed
+		*styles = styleTable{}
+	}
+
+	rows := binaryWriter{}
+	rows.uint(uint64(len(diff.changed) + len(diff.appended)))
+	for _, list := range [][]*Row{diff.changed, diff.appended} {
+		for _, row := range list {
+			var flags uint64
+			if row.HasMark(promptStart) {
+				flags |= BINARY_ROW_PROMPT
+			}
+			rows.uint(row.id)
+			rows.uint(flags)
+			rows.uint(uint64(len(row.attrs)))
+			for _, attr := range row.attrs {
+				rows.uint(styles.id(&attr.style))
+				rows.string(string(row.text[attr.start-1 : attr.end]))
+			}
+		}
+	}
+
+	var flags byte
+	if diff.full {
+		flags |= BINARY_FULL
+	}
+	if t.connected {
+		flags |= BINARY_CONNECTED
+	}
+	if t.cursorHidden {
+		flags |= BINARY_CURSOR_HIDDEN
+	}
+	w := binaryWriter{buf: []byte{'P', BINARY_VERSION, flags}}
+	if diff.full {
+		w.string(t.paletteStyle())
+	}
+	w.uint(diff.active.id)
+	w.uint(uint64(t.cursor.x))
+	w.uint(uint64(len(styles.keys) - styles.sent))
+	for i, key := range styles.keys[styles.sent:] {
+		w.uint(uint64(styles.sent + i + 1))
+		w.string(key.classes)
+		w.string(key.css)
+		w.string(key.href)
+		w.string(key.linkId)
+	}
+	styles.sent = len(styles.keys)
+	w.uint(uint64(len(diff.removed)))
+	for _, id := range diff.removed {
+		w.uint(id)
+	}
+	w.buf = append(w.buf, rows.buf...)
+	return w.buf
+}
@@ -0,0 +1,101 @@
+package terminal
+
+import (
+	"maps"
+	"slices"
+)
+
+// Checkpoint is a copy of the emulator state, used to seek in recordings.
+// The connection, the render state and the pending events are not included.
+type Checkpoint struct {
+	title            string
+	cwd              string
+	staticTitle      string
+	rows             int
+	columns          int
+	style            Style
+	cursor           Cursor
+	cursorMemory     Cursor
+	cursorHidden     bool
+	altScreenEnabled bool
+	bracketedPaste   bool
+	screen           Screen
+	altScreen        Screen
+	palette          Palette
+}
+
+// Checkpoint returns a copy of the state, or false in the middle of an escape
+// sequence or a character, which are not copied.
+func (t *Terminal) Checkpoint() (*Checkpoint, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.eState.enabled || len(t.partial) > 0 {
+		return nil, false
+	}
+	return &Checkpoint{
+		title:            t.title,
+		cwd:              t.cwd,
+		staticTitle:      t.staticTitle,
+		rows:             t.rows,
+		columns:          t.columns,
+		style:            t.style,
+		cursor:           t.cursor,
+		cursorMemory:     t.cursorMemory,
+		cursorHidden:     t.cursorHidden,
+		altScreenEnabled: t.altScreenEnabled,
+		bracketedPaste:   t.bracketedPaste,
+		screen:           t.screen.clone(),
+		altScreen:        t.altScreen.clone(),
+		palette:          t.palette.clone(),
+	}, true
+}
+
+// Restore sets the state saved by Checkpoint, the next Update is a full render.
+func (t *Terminal) Restore(c *Checkpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = nil
+	t.eState = NewEscapeState()
+	t.title = c.title
+	t.cwd = c.cwd
+	t.staticTitle = c.staticTitle
+	t.titleUpdate = true
+	t.rows = c.rows
+	t.columns = c.columns
+	t.style = c.style
+	t.cursor = c.cursor
+	t.cursorMemory = c.cursorMemory
+	t.cursorHidden = c.cursorHidden
+	t.altScreenEnabled = c.altScreenEnabled
+	t.bracketedPaste = c.bracketedPaste
+	screen, altScreen := c.screen.clone(), c.altScreen.clone()
+	screen.term, altScreen.term = t, t
+	t.screen, t.altScreen = &screen, &altScreen
+	t.palette = c.palette.clone()
+	t.render.synced = false
+}
+
+func (s *Screen) clone() Screen {
+	buffor := make([]Row, len(s.buffor))
+	for i := range s.buffor {
+		buffor[i] = s.buffor[i].clone()
+	}
+	scrollback := s.scrollback
+	// encoded rows are never modified, the data can be shared
+	scrollback.rows = slices.Clone(s.scrollback.rows)
+	return Screen{buffor: buffor, scrollback: scrollback}
+}
+
+func (r *Row) clone() Row {
+	row := *r
+	row.text = slices.Clone(r.text)
+	row.attrs = slices.Clone(r.attrs)
+	row.marks = slices.Clone(r.marks)
+	return row
+}
+
+func (p *Palette) clone() Palette {
+	palette := *p
+	palette.colors = maps.Clone(p.colors)
+	return palette
+}
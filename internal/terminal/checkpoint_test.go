@@ -0,0 +1,46 @@
+package terminal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointRestore(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(2, 10)
+	process(term, "\x1b]0;first\x07a\r\nb\r\n\x1b[1mc")
+	checkpoint, ok := term.Checkpoint()
+	if !ok {
+		t.Fatal("Checkpoint not taken")
+	}
+	want := screenText(term)
+
+	process(term, "\x1b]0;second\x07\x1b[0m\x1b[2J\x1b[Hchanged")
+	term.SetSize(3, 20)
+	term.Restore(checkpoint)
+
+	if result := screenText(term); !reflect.DeepEqual(result, want) {
+		t.Errorf("Restored screen: %#q want: %#q", result, want)
+	}
+	if term.Title() != "first" || term.rows != 2 || term.columns != 10 {
+		t.Errorf("Restored title: %#q size: %dx%d", term.Title(), term.rows, term.columns)
+	}
+	process(term, "d")
+	if result := term.screen.GetCurrentRow().Html(); result != `<span class="bold">cd</span>` {
+		t.Errorf("Row after restore: %#q", result)
+	}
+
+	// the checkpoint is not changed by the restored terminal
+	term.Restore(checkpoint)
+	if result := term.screen.GetCurrentRow().Text(); result != "c" {
+		t.Errorf("Second restore: %#q", result)
+	}
+}
+
+func TestCheckpointInEscape(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\x1b[1")
+	if _, ok := term.Checkpoint(); ok {
+		t.Errorf("Checkpoint taken in an escape sequence")
+	}
+}
@@ -0,0 +1,57 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Base64 encoded OSC 52 payloads above this size are dropped.
+const ClipboardLimit = 1 << 20
+
+type ClipboardRequest struct {
+	Selection string
+	Query     bool
+	Text      string
+}
+
+func (t *Terminal) RequestClipboard(selection, data string) {
+	if len(selection) == 0 {
+		selection = "c"
+	}
+	if data == "?" {
+		t.clipboard = append(t.clipboard, ClipboardRequest{Selection: selection, Query: true})
+		return
+	}
+	if len(data) > ClipboardLimit {
+		return
+	}
+	text, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+	t.clipboard = append(t.clipboard, ClipboardRequest{Selection: selection, Text: string(text)})
+}
+
+// TakeClipboard returns the clipboard requests received since the last call.
+func (t *Terminal) TakeClipboard() []ClipboardRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests := t.clipboard
+	t.clipboard = nil
+	return requests
+}
+
+// ReplyClipboard answers an OSC 52 query with the browser clipboard content.
+func (t *Terminal) ReplyClipboard(selection, text string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stdin == nil {
+		return fmt.Errorf("terminal not connected")
+	}
+	data := base64.StdEncoding.EncodeToString([]byte(text))
+	if len(data) > ClipboardLimit {
+		return fmt.Errorf("clipboard content too big")
+	}
+	_, err := t.stdin.Write([]byte(fmt.Sprintf("%c]52;%s;%s%c", esc, selection, data, bel)))
+	return err
+}
@@ -35,6 +35,8 @@ type CSI struct {
 type OSC struct {
 	command int
 	content string
+	escaped bool
+	st      bool
 }
 type G0CharSet struct {
 }
@@ -49,6 +51,7 @@ var csiActions = map[byte]csiAction{
 			style = style.Add(0)
 		}
 		style = style.AddStyles(args)
+		style.link = term.style.link // SGR doesn't close hyperlinks
 		term.AddStyle(style)
 	},
 
@@ -179,7 +182,7 @@ var csiActions = map[byte]csiAction{
 	},
 
 	'n': func(term *Terminal, args []int) { // request cursor position
-		if len(args) == 1 && args[0] == 6 {
+		if len(args) == 1 && args[0] == 6 && term.stdin != nil { // no replies during playback
 			// CSI r ; c R
 			fmt.Println("Sending cursor position back!")
 			term.stdin.Write([]byte(fmt.Sprintf("%c[%d;%dR", esc, term.cursor.y, term.cursor.x)))
@@ -223,7 +226,7 @@ var csiPrivateActions = map[int]csiPrivateAction{
 		term.altScreenEnabled = enable
 	},
 	2004: func(term *Terminal, enable bool) {
-		fmt.Println("Bracketed paste mode:", enable)
+		term.bracketedPaste = enable
 	},
 }
 
@@ -286,34 +289,68 @@ func (ec *CSI) Execute(term *Terminal) {
 	}
 }
 
-func (OSC) IsFinished(r rune) bool {
-	return r == bel
+// IsFinished accepts both BEL and ST (ESC \) as the OSC terminator.
+func (ec *OSC) IsFinished(r rune) bool {
+	if r == bel || (ec.escaped && r == '\\') {
+		return true
+	}
+	ec.escaped = r == esc
+	return false
 }
 
 func (ec *OSC) Parse(s string) {
-	elems := strings.Split(s[:len(s)-1], ";")
+	s = strings.TrimSuffix(s, string(bel))
+	ec.st = strings.HasSuffix(s, string(esc)+"\\")
+	s = strings.TrimSuffix(s, string(esc)+"\\")
+	command, content, _ := strings.Cut(s, ";")
 	var err error
-	ec.command, err = strconv.Atoi(elems[0])
+	ec.command, err = strconv.Atoi(command)
 	if err != nil {
-
+		ec.command = -1
 	}
-	ec.content = elems[1]
+	ec.content = content
 }
 
 func (ec *OSC) Execute(term *Terminal) {
-	if ec.command == 0 {
+	switch ec.command {
+	case 0, 2:
 		term.SetTitle(ec.content)
+	case 4: // OSC 4 ; index ; spec|?
+		term.SetPaletteColors(ec.content, ec.st)
+	case 7: // OSC 7 ; file://host/path
+		term.SetCwd(ec.content)
+	case 8: // OSC 8 ; params ; URI
+		params, uri, _ := strings.Cut(ec.content, ";")
+		term.SetHyperlink(params, uri)
+	case 10, 11: // OSC 10/11 ; spec|?
+		term.SetDynamicColor(ec.command, ec.content, ec.st)
+	case 52: // OSC 52 ; selection ; base64 data or ?
+		selection, data, _ := strings.Cut(ec.content, ";")
+		term.RequestClipboard(selection, data)
+	case 133: // OSC 133 ; A|B|C|D[;exit_code]
+		term.MarkPrompt(ec.content)
+	case 104:
+		term.ResetPaletteColors(ec.content)
+	case 110, 111:
+		term.ResetDynamicColor(ec.command)
+	default:
+		fmt.Println("OSC", ec.command, "not implemented")
 	}
 }
 
+// Escape sequences longer than MaxEscapeLength, e.g. an OSC 52 which never
+// ends, are skipped up to their terminator.
+const MaxEscapeLength = ClipboardLimit + 64
+
 type EscapeState struct {
 	enabled    bool
 	escapeCode EscapeCode
-	buffor     string
+	buffor     strings.Builder
+	dropped    bool // the sequence is too long
 }
 
 func NewEscapeState() EscapeState {
-	return EscapeState{enabled: false, escapeCode: nil, buffor: ""}
+	return EscapeState{enabled: false, escapeCode: nil}
 }
 
 func (t *Terminal) ProcessEscape(r rune) bool {
@@ -342,11 +379,20 @@ func (t *Terminal) ProcessEscape(r rune) bool {
 		}
 		return true
 	} else {
-		t.eState.buffor += string(r)
+		if !t.eState.dropped {
+			t.eState.buffor.WriteRune(r)
+			if t.eState.buffor.Len() > MaxEscapeLength {
+				t.eState.buffor.Reset()
+				t.eState.dropped = true
+			}
+		}
 		if t.eState.escapeCode.IsFinished(r) {
-			t.eState.escapeCode.Parse(t.eState.buffor)
-			t.eState.escapeCode.Execute(t)
-			t.eState.buffor = ""
+			if !t.eState.dropped {
+				t.eState.escapeCode.Parse(t.eState.buffor.String())
+				t.eState.escapeCode.Execute(t)
+			}
+			t.eState.buffor.Reset()
+			t.eState.dropped = false
 			t.eState.enabled = false
 			t.eState.escapeCode = nil
 		}
@@ -0,0 +1,195 @@
+package terminal
+
+import (
+	"fmt"
+	"potatossh/internal/theme"
+	"strconv"
+	"strings"
+)
+
+// CSS variables used by the stylesheet for the 16 base colors.
+var paletteVars = [16]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"bblack", "bred", "bgreen", "byellow", "bblue", "bmagenta", "bcyan", "bwhite",
+}
+
+// Palette holds colors changed by the remote application with OSC 4/10/11,
+// only the 16 base colors can be changed.
+type Palette struct {
+	colors     map[int]theme.Color
+	foreground *theme.Color
+	background *theme.Color
+}
+
+func (t *Terminal) SetTheme(th *theme.Theme) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.theme = th
+}
+
+// Color returns the 256 color palette entry, including overrides.
+func (t *Terminal) Color(n int) (theme.Color, bool) {
+	if c, ok := t.palette.colors[n]; ok {
+		return c, true
+	}
+	switch {
+	case n < 0 || n > 255:
+		return theme.Color{}, false
+	case n < 16:
+		if t.theme == nil {
+			return theme.Color{}, false
+		}
+		return t.theme.Ansi(n)
+	case n < 232:
+		n -= 16
+		return theme.Color{R: uint8(values216[n/36]), G: uint8(values216[(n%36)/6]), B: uint8(values216[n%6])}, true
+	default:
+		value := uint8((n-232)*10 + 8)
+		return theme.Color{R: value, G: value, B: value}, true
+	}
+}
+
+func (t *Terminal) Foreground() (theme.Color, bool) {
+	if t.palette.foreground != nil {
+		return *t.palette.foreground, true
+	}
+	if t.theme == nil {
+		return theme.Color{}, false
+	}
+	return t.theme.Foreground, true
+}
+
+func (t *Terminal) Background() (theme.Color, bool) {
+	if t.palette.background != nil {
+		return *t.palette.background, true
+	}
+	if t.theme == nil {
+		return theme.Color{}, false
+	}
+	return t.theme.Background, true
+}
+
+// SetPaletteColors handles OSC 4 ; index ; spec [; index ; spec ...]
+func (t *Terminal) SetPaletteColors(content string, st bool) {
+	args := strings.Split(content, ";")
+	for i := 0; i+1 < len(args); i += 2 {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 || n > 255 {
+			continue
+		}
+		if args[i+1] == "?" {
+			if c, ok := t.Color(n); ok {
+				t.replyColor(fmt.Sprintf("4;%d", n), c, st)
+			}
+		} else if n >= len(paletteVars) {
+			// 256 color cells are rendered with their rgb value, an override
+			// wouldn't be shown
+			fmt.Println("OSC 4 color", n, "can't be changed")
+		} else if c, ok := parseColor(args[i+1]); ok {
+			if t.palette.colors == nil {
+				t.palette.colors = map[int]theme.Color{}
+			}
+			t.palette.colors[n] = c
+		}
+	}
+}
+
+// ResetPaletteColors handles OSC 104 with an optional list of indexes.
+func (t *Terminal) ResetPaletteColors(content string) {
+	if len(content) == 0 {
+		t.palette.colors = nil
+	} else {
+		for _, arg := range strings.Split(content, ";") {
+			if n, err := strconv.Atoi(arg); err == nil {
+				delete(t.palette.colors, n)
+			}
+		}
+	}
+}
+
+// SetDynamicColor handles OSC 10 (foreground) and OSC 11 (background) set and query.
+func (t *Terminal) SetDynamicColor(command int, spec string, st bool) {
+	target := &t.palette.foreground
+	get := t.Foreground
+	if command == 11 {
+		target = &t.palette.background
+		get = t.Background
+	}
+	if spec == "?" {
+		if c, ok := get(); ok {
+			t.replyColor(strconv.Itoa(command), c, st)
+		}
+	} else if c, ok := parseColor(spec); ok {
+		*target = &c
+	}
+}
+
+// ResetDynamicColor handles OSC 110 and OSC 111.
+func (t *Terminal) ResetDynamicColor(command int) {
+	if command == 110 {
+		t.palette.foreground = nil
+	} else {
+		t.palette.background = nil
+	}
+}
+
+// PaletteStyle returns the inline CSS applying the overrides to the session.
+func (t *Terminal) PaletteStyle() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.paletteStyle()
+}
+
+func (t *Terminal) paletteStyle() string {
+	var sb strings.Builder
+	for n, name := range paletteVars {
+		if c, ok := t.palette.colors[n]; ok {
+			sb.WriteString(fmt.Sprintf("--%s: %s;", name, c.String()))
+		}
+	}
+	if c := t.palette.foreground; c != nil {
+		sb.WriteString(fmt.Sprintf("color: %s;", c.String()))
+	}
+	if c := t.palette.background; c != nil {
+		sb.WriteString(fmt.Sprintf("background-color: %s;", c.String()))
+	}
+	return sb.String()
+}
+
+func (t *Terminal) replyColor(prefix string, c theme.Color, st bool) {
+	if t.stdin == nil {
+		return
+	}
+	terminator := string(bel)
+	if st {
+		terminator = string(esc) + "\\"
+	}
+	t.stdin.Write([]byte(fmt.Sprintf("%c]%s;rgb:%04x/%04x/%04x%s", esc, prefix, int(c.R)*257, int(c.G)*257, int(c.B)*257, terminator)))
+}
+
+// parseColor accepts X11 color specs: rgb:r/g/b (1-4 hex digits) and #rgb forms.
+func parseColor(spec string) (theme.Color, bool) {
+	var parts []string
+	if rgb, ok := strings.CutPrefix(spec, "rgb:"); ok {
+		parts = strings.Split(rgb, "/")
+	} else if hex, ok := strings.CutPrefix(spec, "#"); ok && len(hex)%3 == 0 && len(hex) > 0 {
+		size := len(hex) / 3
+		parts = []string{hex[:size], hex[size : 2*size], hex[2*size:]}
+	}
+	if len(parts) != 3 {
+		return theme.Color{}, false
+	}
+	values := [3]uint8{}
+	for i, part := range parts {
+		if len(part) < 1 || len(part) > 4 {
+			return theme.Color{}, false
+		}
+		v, err := strconv.ParseUint(part, 16, 16)
+		if err != nil {
+			return theme.Color{}, false
+		}
+		max := uint64(1)<<(4*len(part)) - 1
+		values[i] = uint8(v * 255 / max)
+	}
+	return theme.Color{R: values[0], G: values[1], B: values[2]}, true
+}
@@ -0,0 +1,131 @@
+package terminal
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// OSC 133 shell integration marks
+const (
+	promptStart  = 'A'
+	commandStart = 'B'
+	outputStart  = 'C'
+	commandEnd   = 'D'
+)
+
+type PromptMark struct {
+	kind     byte
+	x        int
+	exitCode int
+}
+
+// SetCwd stores the working directory reported with OSC 7 (file://host/path).
+func (t *Terminal) SetCwd(uri string) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return
+	}
+	// the path is percent-decoded, it must not smuggle markup into the tab title
+	if strings.ContainsFunc(u.Path, func(r rune) bool { return unicode.IsControl(r) || r == '"' || r == '<' }) {
+		return
+	}
+	if t.cwd != u.Path {
+		t.cwd = u.Path
+		t.titleUpdate = true
+	}
+}
+
+func (t *Terminal) Cwd() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cwd
+}
+
+// MarkPrompt records an OSC 133 mark (A, B, C or D;exit_code) at the cursor.
+func (t *Terminal) MarkPrompt(content string) {
+	if len(content) == 0 {
+		return
+	}
+	mark := PromptMark{kind: content[0], x: t.cursor.x}
+	switch mark.kind {
+	case promptStart, commandStart, outputStart:
+	case commandEnd:
+		args := strings.Split(content, ";")
+		if len(args) > 1 {
+			mark.exitCode, _ = strconv.Atoi(args[1])
+		}
+	default:
+		return
+	}
+	row := t.GetScreen().GetCurrentRow()
+	row.marks = append(row.marks, mark)
+	row.dirty = true
+}
+
+// LastCommandOutput returns the output of the last finished command on the main screen.
+func (t *Terminal) LastCommandOutput() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.screen.LastCommandOutput()
+}
+
+func (s *Screen) LastCommandOutput() (string, bool) {
+	endRow, endIdx := -1, 0
+	for i := s.Len() - 1; i >= 0 && endRow == -1; i-- {
+		marks := s.Row(i).marks
+		for j := len(marks) - 1; j >= 0; j-- {
+			if marks[j].kind == commandEnd {
+				endRow, endIdx = i, j
+				break
+			}
+		}
+	}
+	if endRow == -1 {
+		return "", false
+	}
+	endX := s.Row(endRow).marks[endIdx].x
+	for i := endRow; i >= 0; i-- {
+		marks := s.Row(i).marks
+		j := len(marks) - 1
+		if i == endRow {
+			j = endIdx - 1
+		}
+		for ; j >= 0; j-- {
+			mark := marks[j]
+			if mark.kind == promptStart || mark.kind == commandStart {
+				return "", false // command without output mark
+			}
+			if mark.kind != outputStart {
+				continue
+			}
+			lines := []string{}
+			for k := i; k <= endRow; k++ {
+				text := s.Row(k).text
+				from, to := 0, len(text)
+				if k == i {
+					from = min(mark.x-1, len(text))
+				}
+				if k == endRow {
+					to = min(endX-1, len(text))
+				}
+				if from > to {
+					from = to
+				}
+				lines = append(lines, strings.TrimRight(string(text[from:to]), " "))
+			}
+			return strings.TrimRight(strings.Join(lines, "\n"), "\n"), true
+		}
+	}
+	return "", false
+}
+
+func (r *Row) HasMark(kind byte) bool {
+	for _, mark := range r.marks {
+		if mark.kind == kind {
+			return true
+		}
+	}
+	return false
+}
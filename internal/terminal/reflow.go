@@ -0,0 +1,114 @@
+package terminal
+
+// logicalLine is a line of output split into rows by soft wraps.
+type logicalLine struct {
+	rows  []*Row
+	text  []rune
+	attrs []Attr
+	marks []PromptMark
+}
+
+func (l *logicalLine) add(row *Row) {
+	offset := len(l.text)
+	l.rows = append(l.rows, row)
+	l.text = append(l.text, row.text...)
+	for _, attr := range row.attrs {
+		attr.start += offset
+		attr.end += offset
+		if n := len(l.attrs); n > 0 && l.attrs[n-1].style == attr.style && l.attrs[n-1].end+1 == attr.start {
+			l.attrs[n-1].end = attr.end
+		} else {
+			l.attrs = append(l.attrs, attr)
+		}
+	}
+	for _, mark := range row.marks {
+		mark.x += offset
+		l.marks = append(l.marks, mark)
+	}
+}
+
+// split returns the line as rows of the given width. n is the minimal number of rows.
+func (l *logicalLine) split(columns, n int) []Row {
+	n = max(n, (len(l.text)+columns-1)/columns, 1)
+	rows := make([]Row, n)
+	for i := range rows {
+		row := newRow()
+		from, to := min(i*columns, len(l.text)), min((i+1)*columns, len(l.text))
+		row.text = append([]rune(nil), l.text[from:to]...)
+		for _, attr := range l.attrs {
+			start, end := max(attr.start, from+1), min(attr.end, to)
+			if start <= end {
+				row.attrs = append(row.attrs, Attr{start: start - from, end: end - from, style: attr.style})
+			}
+		}
+		row.wrapped = i < n-1
+		rows[i] = row
+	}
+	for _, mark := range l.marks {
+		i := min((mark.x-1)/columns, n-1)
+		mark.x -= i * columns
+		rows[i].marks = append(rows[i].marks, mark)
+	}
+	return rows
+}
+
+// reflow rewraps the soft-wrapped lines of the screen and its scrollback for a new
+// width, the cursor stays on the same character.
+func (s *Screen) reflow(columns int) {
+	cursorRow := s.GetCurrentRow()
+	all := make([]*Row, 0, s.Len())
+	for i := range s.Len() {
+		all = append(all, s.Row(i))
+	}
+
+	rows := make([]Row, 0, len(all))
+	cursor := -1
+	for i := 0; i < len(all); {
+		line := logicalLine{}
+		cursorOffset := -1
+		for {
+			if all[i] == cursorRow {
+				cursorOffset = len(line.text) + s.term.cursor.x - 1
+			}
+			line.add(all[i])
+			i++
+			if !all[i-1].wrapped || i == len(all) {
+				break
+			}
+		}
+		if len(line.rows) == 1 && len(line.text) <= columns && cursorOffset < columns {
+			// nothing to rewrap, the row keeps its id
+			if cursorOffset >= 0 {
+				cursor = len(rows)
+			}
+			rows = append(rows, *line.rows[0])
+			continue
+		}
+
+		minRows := 0
+		if cursorOffset >= 0 && cursorOffset < len(line.text) || cursorOffset > 0 && cursorOffset%columns != 0 {
+			minRows = cursorOffset/columns + 1
+		}
+		split := line.split(columns, minRows)
+		if cursorOffset >= 0 {
+			// a cursor after the last character of a full row waits for the next one to wrap
+			i := min(cursorOffset/columns, len(split)-1)
+			cursor = len(rows) + i
+			s.term.cursor.x = cursorOffset - i*columns + 1
+		}
+		rows = append(rows, split...)
+	}
+
+	// the screen shows the last rows, unless the cursor would be above it
+	top := max(min(len(rows)-s.term.rows, cursor), 0)
+	if len(rows)-top > s.term.rows {
+		rows = rows[:top+s.term.rows]
+	}
+	s.scrollback.Clear()
+	s.reset = true
+	for i := range top {
+		s.scrollback.Push(&rows[i])
+	}
+	s.buffor = rows[top:]
+	s.term.cursor.y = cursor - top + 1
+}
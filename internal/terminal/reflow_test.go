@@ -0,0 +1,129 @@
+package terminal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func screenText(term *Terminal) []string {
+	lines := []string{}
+	for i := range term.screen.Len() {
+		lines = append(lines, term.screen.Row(i).Text())
+	}
+	return lines
+}
+
+func TestReflowShrink(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 10)
+	process(term, "0123456789abcde\r\n\x1b[1mshort\x1b[0m\r\nxyz")
+	term.SetSize(5, 4)
+
+	result := screenText(term)
+	want := []string{"0123", "4567", "89ab", "cde", "shor", "t", "xyz"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Reflow result: %#q want: %#q", result, want)
+	}
+	if term.screen.scrollback.Len() != 2 || len(term.screen.buffor) != 5 {
+		t.Errorf("Scrollback rows: %d screen rows: %d", term.screen.scrollback.Len(), len(term.screen.buffor))
+	}
+	if row := term.screen.Row(4); row.Html() != `<span class="bold">shor</span>` || !row.wrapped {
+		t.Errorf("Reflowed row: %#q wrapped: %v", row.Html(), row.wrapped)
+	}
+	if result := term.screen.GetCurrentRow().Text(); result != "xyz" || term.cursor.x != 4 {
+		t.Errorf("Cursor row: %#q x: %d", result, term.cursor.x)
+	}
+}
+
+func TestReflowGrow(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 4)
+	process(term, "0123456789\r\nab")
+	term.SetSize(5, 20)
+
+	result := screenText(term)
+	want := []string{"0123456789", "ab"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Reflow result: %#q want: %#q", result, want)
+	}
+	if term.cursor.y != 2 || term.cursor.x != 3 {
+		t.Errorf("Cursor after reflow: %v", term.cursor)
+	}
+
+	process(term, "c")
+	if result := term.screen.Row(1).Text(); result != "abc" {
+		t.Errorf("Text after reflow: %#q", result)
+	}
+}
+
+func TestReflowCursorInWrappedLine(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 4)
+	process(term, "0123456789\x1b[2D")
+	term.SetSize(5, 3)
+
+	// the cursor stays on "8"
+	if result := term.screen.GetCurrentRow().Text(); result != "678" || term.cursor.x != 3 {
+		t.Errorf("Cursor row: %#q x: %d", result, term.cursor.x)
+	}
+	term.SetSize(5, 10)
+	if result := term.screen.GetCurrentRow().Text(); result != "0123456789" || term.cursor.x != 9 {
+		t.Errorf("Cursor row: %#q x: %d", result, term.cursor.x)
+	}
+}
+
+func TestReflowPendingWrap(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 4)
+	process(term, "abcdefgh")
+	term.SetSize(5, 8)
+
+	process(term, "i")
+	result := screenText(term)
+	want := []string{"abcdefgh", "i"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Reflow result: %#q want: %#q", result, want)
+	}
+}
+
+func TestReflowAltScreen(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 10)
+	process(term, "\x1b[?1049h0123456789")
+	term.SetSize(5, 4)
+
+	if result := term.altScreen.GetCurrentRow().Text(); result != "0123456789" {
+		t.Errorf("Alternate screen should not be reflowed: %#q", result)
+	}
+}
+
+func TestReflowErasedWrap(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 4)
+	process(term, "abcdefgh1234x")
+	process(term, "\x1b[H\x1b[2Kaa\x1b[2;1H\x1b[Kbb\x1b[3;3H\n")
+	term.SetSize(5, 10)
+
+	result := screenText(term)
+	want := []string{"aa", "bb", "1234", "x"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Reflow result: %#q want: %#q", result, want)
+	}
+}
+
+func TestReflowBehindAltScreen(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(5, 10)
+	process(term, "0123456789abc\x1b[?1049hvim")
+	term.SetSize(5, 4)
+	process(term, "\x1b[?1049l")
+
+	result := screenText(term)
+	want := []string{"0123", "4567", "89ab", "c"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Main screen after the alternate one: %#q want: %#q", result, want)
+	}
+	if term.cursor.y != 4 || term.cursor.x != 2 {
+		t.Errorf("Cursor after the alternate screen: %v", term.cursor)
+	}
+}
@@ -0,0 +1,149 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// renderState remembers what the browser shows, so only changes are sent.
+type renderState struct {
+	synced      bool
+	connected   bool
+	screen      *Screen
+	style       string
+	ids         []uint64 // rows shown, oldest first
+	dropped     int      // scrollback rows dropped when synced
+	cursorRow   uint64
+	cursorIndex int // position of the cursor row, counting the dropped rows
+	cursorX     int
+	hidden      bool
+	styles      styleTable
+}
+
+// rowsDiff lists the rows to send since the last update.
+type rowsDiff struct {
+	full     bool
+	removed  []uint64
+	changed  []*Row // rows already shown in the browser
+	appended []*Row // rows added at the bottom
+	active   *Row   // row with the cursor
+}
+
+// Resync makes the next Update request a full render, e.g. after a new browser attached.
+func (t *Terminal) Resync() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.render.synced = false
+}
+
+// Update returns the rows changed since the last call as htmx out of band swaps
+// for the code element with the target id. When full is true the update is the
+// whole code element, rendered with the same state as the one marked as sent.
+func (t *Terminal) Update(target string) (update string, full bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	screen := t.GetScreen()
+	diff := t.diff()
+	if diff.full {
+		return fmt.Sprintf("<code id=\"%s\" style=\"%s\">%s</code>", target, t.paletteStyle(), t.string()), true
+	}
+
+	var sb strings.Builder
+	for _, id := range diff.removed {
+		sb.WriteString(fmt.Sprintf("<span id=\"r%d\" hx-swap-oob=\"delete\"></span>", id))
+	}
+	for _, row := range diff.changed {
+		sb.WriteString(screen.rowHtml(row, row == diff.active, " hx-swap-oob=\"true\""))
+	}
+	if len(diff.appended) > 0 {
+		sb.WriteString(fmt.Sprintf("<div hx-swap-oob=\"beforeend:#%s\">", target))
+		for _, row := range diff.appended {
+			sb.WriteString(screen.rowHtml(row, row == diff.active, ""))
+		}
+		sb.WriteString("</div>")
+	}
+	return sb.String(), false
+}
+
+// diff compares the screen with the last state sent and marks the screen as sent.
+// Rows are only dropped from the top and appended at the bottom, so it counts
+// them instead of looking at every row of the scrollback.
+func (t *Terminal) diff() rowsDiff {
+	screen := t.GetScreen()
+	state := &t.render
+	diff := rowsDiff{active: screen.GetCurrentRow()}
+	if !state.synced || state.connected != t.connected || state.screen != screen || state.style != t.paletteStyle() || screen.reset {
+		return t.fullDiff(screen, diff)
+	}
+
+	dropped := min(screen.scrollback.dropped-state.dropped, len(state.ids))
+	shown := len(state.ids) - dropped
+	total := screen.Len()
+	if shown > total || shown > 0 && screen.rowId(shown-1) != state.ids[len(state.ids)-1] {
+		return t.fullDiff(screen, diff)
+	}
+	diff.removed = state.ids[:dropped:dropped]
+
+	cursorMoved := state.cursorRow != diff.active.id || state.cursorX != t.cursor.x || state.hidden != t.cursorHidden
+	if cursorMoved {
+		diff.active.dirty = true
+	}
+	// only the rows scrolled off since the last update and the buffor can be dirty
+	history := screen.scrollback.Len()
+	from := min(history-min(screen.scrollback.fresh, history), shown)
+	if i := state.cursorIndex - screen.scrollback.dropped; cursorMoved && i >= 0 && i < from && screen.rowId(i) == state.cursorRow {
+		diff.changed = append(diff.changed, screen.Row(i))
+	}
+	for i := from; i < total; i++ {
+		if i >= shown {
+			diff.appended = append(diff.appended, screen.Row(i))
+		} else if i < history {
+			scrolled := &screen.scrollback.rows[screen.scrollback.index(i)]
+			if scrolled.dirty || (cursorMoved && scrolled.id == state.cursorRow) {
+				diff.changed = append(diff.changed, screen.Row(i))
+			}
+		} else if row := screen.Row(i); row.dirty || (cursorMoved && row.id == state.cursorRow) {
+			diff.changed = append(diff.changed, row)
+		}
+	}
+	state.ids = state.ids[dropped:]
+	for _, row := range diff.appended {
+		state.ids = append(state.ids, row.id)
+	}
+	t.sync(screen, diff.active)
+	return diff
+}
+
+func (t *Terminal) fullDiff(screen *Screen, diff rowsDiff) rowsDiff {
+	diff.full = true
+	t.render.ids = t.render.ids[:0]
+	for i := range screen.Len() {
+		row := screen.Row(i)
+		diff.appended = append(diff.appended, row)
+		t.render.ids = append(t.render.ids, row.id)
+	}
+	t.sync(screen, diff.active)
+	return diff
+}
+
+// sync marks the current state of the screen as sent to the browser, the
+// callers update the ids of the rows shown.
+func (t *Terminal) sync(screen *Screen, active_row *Row) {
+	state := &t.render
+	state.synced = true
+	state.connected = t.connected
+	state.screen = screen
+	state.style = t.paletteStyle()
+	state.dropped = screen.scrollback.dropped
+	screen.reset = false
+	for i := range screen.buffor {
+		screen.buffor[i].dirty = false
+		if &screen.buffor[i] == active_row {
+			state.cursorIndex = screen.scrollback.dropped + screen.scrollback.Len() + i
+		}
+	}
+	screen.scrollback.clean()
+	state.cursorRow = active_row.id
+	state.cursorX = t.cursor.x
+	state.hidden = t.cursorHidden
+}
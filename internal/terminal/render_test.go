@@ -0,0 +1,197 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUpdateBinary(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	term.UpdateBinary()
+
+	process(term, "\x1b[1ma\x1b[0mb")
+	id := term.screen.buffor[0].id
+
+	result := term.UpdateBinary()
+	w := binaryWriter{buf: []byte{'P', BINARY_VERSION, BINARY_CONNECTED}}
+	w.uint(id) // cursor
+	w.uint(3)
+	w.uint(1) // styles
+	w.uint(1)
+	w.string("bold")
+	w.string("")
+	w.string("")
+	w.string("")
+	w.uint(0) // removed
+	w.uint(1) // rows
+	w.uint(id)
+	w.uint(0)
+	w.uint(2)
+	w.uint(1)
+	w.string("a")
+	w.uint(0)
+	w.string("b")
+
+	if !bytes.Equal(result, w.buf) {
+		t.Errorf("Binary result: %v want: %v", result, w.buf)
+	}
+	if result := term.UpdateBinary(); result != nil {
+		t.Errorf("Binary update without changes: %v", result)
+	}
+}
+
+func TestBinaryStyleTable(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	for i := range MAX_BINARY_STYLES + 1 {
+		// styles used once and overwritten by the next one
+		process(term, fmt.Sprintf("\r\x1b[38;2;%d;%d;0mx", i/256, i%256))
+		term.UpdateBinary()
+	}
+	process(term, "\x1b[0m\r\x1b[1mbold")
+	if result := term.UpdateBinary(); result[2]&BINARY_FULL == 0 {
+		t.Errorf("No full update after %d styles", MAX_BINARY_STYLES+1)
+	}
+	if n := len(term.render.styles.keys); n != 1 {
+		t.Errorf("Styles after the full update: %d", n)
+	}
+
+	process(term, "\x1b[0m\r\x1b[2Kplain")
+	term.UpdateBinary()
+	term.Resync()
+	term.UpdateBinary()
+	if n := len(term.render.styles.keys); n != 0 {
+		t.Errorf("Styles after a resync: %d", n)
+	}
+}
+
+func seqOutput(n int) []byte {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteString("\r\n")
+	}
+	return []byte(sb.String())
+}
+
+// benchmarkSeq feeds the output of `seq 1 1000000` in 4k chunks, rendering an update after each one.
+// The size of the rendered updates is reported as sent-B/op.
+func benchmarkSeq(b *testing.B, render func(term *Terminal) int) {
+	output := seqOutput(1000000)
+	sent := 0
+	b.ResetTimer()
+	for range b.N {
+		term := NewTerminal("bench")
+		term.Connected(&stdinRecorder{})
+		for start := 0; start < len(output); start += 4096 {
+			term.Write(output[start:min(start+4096, len(output))])
+			sent += render(term)
+		}
+	}
+	b.ReportMetric(float64(sent)/float64(b.N), "sent-B/op")
+}
+
+// benchmarkThroughput feeds mixed ASCII, UTF-8 and SGR output in chunks of the given size.
+func benchmarkThroughput(b *testing.B, chunk int, feed func(term *Terminal, data []byte)) {
+	var sb strings.Builder
+	for i := range 20000 {
+		sb.WriteString(fmt.Sprintf("\x1b[3%dm%d zażółć gęślą jaźń ✓\x1b[0m plain text\r\n", i%8, i))
+	}
+	output := []byte(sb.String())
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for range b.N {
+		term := NewTerminal("bench")
+		term.Connected(&stdinRecorder{})
+		for start := 0; start < len(output); start += chunk {
+			feed(term, output[start:min(start+chunk, len(output))])
+		}
+	}
+}
+
+func BenchmarkProcessCharacter(b *testing.B) {
+	benchmarkThroughput(b, 4096, func(term *Terminal, data []byte) {
+		for _, r := range string(data) {
+			term.ProcessCharacter(r)
+		}
+	})
+}
+
+func BenchmarkWrite4k(b *testing.B) {
+	benchmarkThroughput(b, 4096, func(term *Terminal, data []byte) { term.Write(data) })
+}
+
+func BenchmarkWrite32k(b *testing.B) {
+	benchmarkThroughput(b, 32*1024, func(term *Terminal, data []byte) { term.Write(data) })
+}
+
+func BenchmarkWrite7(b *testing.B) {
+	// odd chunk size, most multi-byte characters are split between writes
+	benchmarkThroughput(b, 7, func(term *Terminal, data []byte) { term.Write(data) })
+}
+
+func BenchmarkSeqProcessOnly(b *testing.B) {
+	benchmarkSeq(b, func(term *Terminal) int { return 0 })
+}
+
+func BenchmarkSeqHtml(b *testing.B) {
+	benchmarkSeq(b, func(term *Terminal) int {
+		update, _ := term.Update("code")
+		return len(update)
+	})
+}
+
+func BenchmarkSeqBinary(b *testing.B) {
+	benchmarkSeq(b, func(term *Terminal) int {
+		return len(term.UpdateBinary())
+	})
+}
+
+func fullScreen() *Terminal {
+	term := NewTerminal("bench")
+	term.Connected(&stdinRecorder{})
+	term.Write(seqOutput(1000))
+	process(term, "\x1b[1;31mred\x1b[0m \x1b[38;2;1;2;3mrgb\x1b[0m")
+	return term
+}
+
+func BenchmarkFullHtml(b *testing.B) {
+	term := fullScreen()
+	for range b.N {
+		term.Resync()
+		term.Update("code")
+	}
+}
+
+func BenchmarkFullBinary(b *testing.B) {
+	term := fullScreen()
+	for range b.N {
+		term.Resync()
+		term.UpdateBinary()
+	}
+}
+
+func TestUpdateBinaryFull(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\x1b]11;#000000\x07")
+
+	result := term.UpdateBinary()
+	w := binaryWriter{buf: []byte{'P', BINARY_VERSION, BINARY_FULL}}
+	w.string("background-color: #000000;")
+	w.uint(term.screen.buffor[0].id)
+	w.uint(1)
+	w.uint(0) // styles
+	w.uint(0) // removed
+	w.uint(1) // rows
+	w.uint(term.screen.buffor[0].id)
+	w.uint(0)
+	w.uint(0)
+
+	if !bytes.Equal(result, w.buf) {
+		t.Errorf("Binary result: %v want: %v", result, w.buf)
+	}
+}
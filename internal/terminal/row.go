@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"html"
 	"slices"
 	"strings"
 )
@@ -18,14 +19,22 @@ type Attr struct {
 }
 
 type Row struct {
-	text  []rune
-	attrs []Attr
+	id      uint64
+	dirty   bool // changed since the last update sent to the browser
+	wrapped bool // the text continues on the next row
+	text    []rune
+	attrs   []Attr
+	marks   []PromptMark
 }
 
 func (r *Row) Length() int {
 	return len(r.text)
 }
 
+func (r *Row) Text() string {
+	return string(r.text)
+}
+
 func (r *Row) GetAttr(x int) (int, *Attr) {
 	if x < 1 || x > r.Length() {
 		return -1, nil
@@ -69,6 +78,7 @@ func (r *Row) GetAttr(x int) (int, *Attr) {
 //    y
 
 func (r *Row) AddText(letter rune, x int, s *Style) {
+	r.dirty = true
 	length := r.Length()
 	if x > length {
 		_, previousAttr := r.GetAttr(x - 1)
@@ -127,6 +137,7 @@ func (r *Row) AddText(letter rune, x int, s *Style) {
 
 func (r *Row) InsertText(letter rune, x int, s *Style) {
 	// TODO
+	r.dirty = true
 	length := r.Length()
 	if x > length {
 		r.AddText(' ', x, s)
@@ -143,6 +154,7 @@ func (r *Row) InsertText(letter rune, x int, s *Style) {
 }
 
 func (r *Row) AddTexts(letters []rune, x int, s *Style) {
+	r.dirty = true
 	length := r.Length()
 	lettersLength := len(letters)
 
@@ -188,6 +200,8 @@ func (r *Row) AddTexts(letters []rune, x int, s *Style) {
 }
 
 func (r *Row) Clear() {
+	r.dirty = true
+	r.wrapped = false
 	r.text = []rune{}
 	r.attrs = []Attr{}
 }
@@ -196,6 +210,8 @@ func (r *Row) ClearToEnd(x int) {
 	if x > len(r.text) {
 		return
 	}
+	r.dirty = true
+	r.wrapped = false
 	i, currentAttr := r.GetAttr(x)
 	r.text = r.text[:x-1]
 	currentAttr.end = x - 1
@@ -206,6 +222,8 @@ func (r *Row) RemoveN(x, N int) {
 	if x < 1 || N < 1 || x > len(r.text) {
 		return
 	}
+	r.dirty = true
+	r.wrapped = false
 	end := x + N - 1
 	if end > len(r.text) {
 		end = len(r.text)
@@ -263,7 +281,11 @@ xyzxyzxyz
 		  12345
 */
 func (r *Row) EraseToNO(x, N int) {
+	r.dirty = true
 	length := len(r.text)
+	if x+N-1 >= length {
+		r.wrapped = false
+	}
 	if x > length {
 		r.text = append(r.text, []rune(strings.Repeat(" ", x+N-length-1))...)
 		r.attrs = append(r.attrs, Attr{start: length, end: x + N - 1, style: NewStyle()})
@@ -286,6 +308,9 @@ func (r *Row) EraseToNO(x, N int) {
 }
 
 func (r *Row) EraseToN(x, N int) {
+	if x+N-1 >= len(r.text) {
+		r.wrapped = false
+	}
 	style := NewStyle()
 	for i := range N {
 		r.AddText(' ', x+i, &style)
@@ -296,15 +321,20 @@ func (r *Row) Html() string {
 	var sb strings.Builder
 
 	for _, attr := range r.attrs {
-		if !attr.style.IsEmpty() {
+		link := openLink(&sb, attr.style.link)
+		styled := attr.style.HasLook()
+		if styled {
 			sb.WriteString("<span ")
 			sb.WriteString(attr.style.Attributes())
 			sb.WriteString(">")
 		}
-		sb.WriteString(string(r.text[attr.start-1 : attr.end]))
-		if !attr.style.IsEmpty() {
+		sb.WriteString(html.EscapeString(string(r.text[attr.start-1 : attr.end])))
+		if styled {
 			sb.WriteString("</span>")
 		}
+		if link {
+			sb.WriteString("</a>")
+		}
 	}
 	return sb.String()
 }
@@ -313,23 +343,28 @@ func (r *Row) HtmlWithCursor(x int) string {
 	var sb strings.Builder
 	offset := 0
 	for _, attr := range r.attrs {
-		if !attr.style.IsEmpty() {
+		link := openLink(&sb, attr.style.link)
+		styled := attr.style.HasLook()
+		if styled {
 			sb.WriteString("<span class=\"")
 			sb.WriteString(attr.style.Classes())
 			sb.WriteString("\">")
 		}
 		if x >= attr.start && x <= attr.end {
-			sb.WriteString(string(r.text[attr.start-1 : x-1]))
+			sb.WriteString(html.EscapeString(string(r.text[attr.start-1 : x-1])))
 			sb.WriteString("<span class=\"cursor\">")
-			sb.WriteRune(r.text[x-1])
+			sb.WriteString(html.EscapeString(string(r.text[x-1])))
 			sb.WriteString("</span>")
-			sb.WriteString(string(r.text[x:attr.end]))
+			sb.WriteString(html.EscapeString(string(r.text[x:attr.end])))
 		} else {
-			sb.WriteString(string(r.text[attr.start-1 : attr.end]))
+			sb.WriteString(html.EscapeString(string(r.text[attr.start-1 : attr.end])))
 		}
-		if !attr.style.IsEmpty() {
+		if styled {
 			sb.WriteString("</span>")
 		}
+		if link {
+			sb.WriteString("</a>")
+		}
 		offset = attr.end
 	}
 	if x > offset {
@@ -339,3 +374,25 @@ func (r *Row) HtmlWithCursor(x int) string {
 
 	return sb.String()
 }
+
+// openLink writes the <a> start tag for a hyperlink with an allowed scheme.
+// Links with other schemes are rendered as plain text.
+func openLink(sb *strings.Builder, link *Hyperlink) bool {
+	if link == nil {
+		return false
+	}
+	href, ok := link.Href()
+	if !ok {
+		return false
+	}
+	sb.WriteString("<a href=\"")
+	sb.WriteString(html.EscapeString(href))
+	sb.WriteString("\" target=\"_blank\" rel=\"noopener noreferrer\"")
+	if len(link.id) > 0 {
+		sb.WriteString(" data-link-id=\"")
+		sb.WriteString(html.EscapeString(link.id))
+		sb.WriteString("\"")
+	}
+	sb.WriteString(">")
+	return true
+}
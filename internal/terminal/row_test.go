@@ -3,6 +3,7 @@ package terminal
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -671,3 +672,104 @@ func TestEraseToNOver(t *testing.T) {
 	}
 	fmt.Println("RESULT", result, row.attrs)
 }
+
+func TestHtmlEscape(t *testing.T) {
+	row := Row{}
+	reset := NewStyle()
+	for i, r := range "<b>&" {
+		row.AddText(r, i+1, &reset)
+	}
+
+	result := row.Html()
+	want := "&lt;b&gt;&amp;"
+
+	if result != want {
+		t.Errorf("Row result: %#q want: %#q", result, want)
+	}
+}
+
+func TestHyperlink(t *testing.T) {
+	row := Row{}
+	reset := NewStyle()
+	link := NewStyle()
+	link.link = &Hyperlink{id: "1", uri: "https://example.com/?a=1&b=2"}
+
+	row.AddText('a', 1, &reset)
+	row.AddText('b', 2, &link)
+	row.AddText('c', 3, &link)
+	row.AddText('d', 4, &reset)
+
+	result := row.Html()
+	want := "a<a href=\"https://example.com/?a=1&amp;b=2\" target=\"_blank\" rel=\"noopener noreferrer\" data-link-id=\"1\">bc</a>d"
+
+	if result != want {
+		t.Errorf("Row result: %#q want: %#q", result, want)
+	}
+}
+
+func TestHyperlinkUnsafeScheme(t *testing.T) {
+	row := Row{}
+	link := NewStyle().Add(1)
+	link.link = &Hyperlink{uri: "javascript:alert(1)"}
+
+	row.AddText('x', 1, &link)
+
+	result := row.Html()
+	want := "<span class=\"bold\">x</span>"
+
+	if result != want {
+		t.Errorf("Row result: %#q want: %#q", result, want)
+	}
+}
+
+func TestHyperlinkEscapeSequence(t *testing.T) {
+	term := NewTerminal("test")
+	for _, r := range "a\x1b]8;id=x;http://host/\x1b\\b\x1b[1mc\x1b]8;;\x07d" {
+		term.ProcessCharacter(r)
+	}
+
+	result := term.GetScreen().GetCurrentRow().Html()
+	want := "a<a href=\"http://host/\" target=\"_blank\" rel=\"noopener noreferrer\" data-link-id=\"x\">b</a><a href=\"http://host/\" target=\"_blank\" rel=\"noopener noreferrer\" data-link-id=\"x\"><span class=\"bold\">c</span></a><span class=\"bold\">d</span>"
+
+	if result != want {
+		t.Errorf("Row result: %#q want: %#q", result, want)
+	}
+}
+
+func TestClipboardRequest(t *testing.T) {
+	term := NewTerminal("test")
+	for _, r := range "\x1b]52;c;aGVsbG8=\x07\x1b]52;;?\x1b\\" {
+		term.ProcessCharacter(r)
+	}
+
+	result := term.TakeClipboard()
+	want := []ClipboardRequest{{Selection: "c", Text: "hello"}, {Selection: "c", Query: true}}
+
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Clipboard result: %v want: %v", result, want)
+	}
+	if len(term.TakeClipboard()) != 0 {
+		t.Errorf("Clipboard requests not consumed")
+	}
+}
+
+func TestClipboardTooLong(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	process(term, "\x1b]52;c;")
+	chunk := strings.Repeat("A", 64*1024)
+	for range ClipboardLimit/len(chunk) + 2 {
+		process(term, chunk)
+		if n := term.eState.buffor.Len(); n > MaxEscapeLength {
+			t.Fatalf("Escape sequence collected: %d bytes", n)
+		}
+	}
+	process(term, "\x07ok")
+
+	if requests := term.TakeClipboard(); len(requests) != 0 {
+		t.Errorf("Too long payload accepted: %d requests", len(requests))
+	}
+	if result := term.screen.GetCurrentRow().Text(); result != "ok" {
+		t.Errorf("Text after the dropped sequence: %#q", result)
+	}
+}
@@ -1,24 +1,88 @@
 package terminal
 
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Row ids are unique across all terminals, so they can be used as element ids on one page.
+var rowIds atomic.Uint64
+
+func newRow() Row {
+	return Row{id: rowIds.Add(1), dirty: true}
+}
+
 type Cursor struct {
 	y, x int
 }
 
+// Screen rows are the scrollback followed by buffor, the rows which can still
+// change. buffor holds the visible rows and grows only until the next scroll.
 type Screen struct {
-	term   *Terminal
-	buffor []Row
+	term       *Terminal
+	buffor     []Row
+	scrollback Scrollback
+	reset      bool // rows replaced, not only scrolled, the browser needs a full render
+}
+
+// Len returns the number of rows including the scrollback.
+func (s *Screen) Len() int {
+	return s.scrollback.Len() + len(s.buffor)
+}
+
+// Row returns the i-th row, scrollback rows are decoded copies.
+func (s *Screen) Row(i int) *Row {
+	if i < s.scrollback.Len() {
+		row := s.scrollback.Row(i)
+		return &row
+	}
+	return &s.buffor[i-s.scrollback.Len()]
+}
+
+func (s *Screen) rowId(i int) uint64 {
+	if i < s.scrollback.Len() {
+		return s.scrollback.rows[s.scrollback.index(i)].id
+	}
+	return s.buffor[i-s.scrollback.Len()].id
+}
+
+// scroll moves the rows above the screen to the scrollback.
+func (s *Screen) scroll() {
+	n := len(s.buffor) - s.term.rows
+	if n <= 0 {
+		return
+	}
+	for i := range n {
+		s.scrollback.Push(&s.buffor[i])
+	}
+	s.buffor = s.buffor[n:]
+}
+
+// unscroll moves up to n newest scrollback rows back to the top of the screen.
+func (s *Screen) unscroll(n int) {
+	n = min(n, s.scrollback.Len())
+	if n <= 0 {
+		return
+	}
+	rows := make([]Row, n, n+len(s.buffor))
+	for i := n - 1; i >= 0; i-- {
+		rows[i] = s.scrollback.Pop()
+	}
+	s.buffor = append(rows, s.buffor...)
 }
 
 func (s *Screen) MoveToNextLine() *Row {
 	if len(s.buffor) < s.term.rows { // buffor smaller than screen
 		if len(s.buffor) == s.term.cursor.y {
-			s.buffor = append(s.buffor, Row{})
+			s.buffor = append(s.buffor, newRow())
 		}
 		s.term.cursor.x = 1
 		s.term.cursor.y++
 	} else {
 		if s.term.rows == s.term.cursor.y {
-			s.buffor = append(s.buffor, Row{})
+			s.buffor = append(s.buffor, newRow())
+			s.scroll()
 		} else {
 			s.term.cursor.y++
 		}
@@ -31,7 +95,7 @@ func (s *Screen) MoveToNextLine() *Row {
 func (s *Screen) GetCurrentRow() *Row {
 	buffor_size := len(s.buffor)
 	if buffor_size == 0 {
-		s.buffor = append(s.buffor, Row{})
+		s.buffor = append(s.buffor, newRow())
 		return &s.buffor[0]
 	}
 	if buffor_size > s.term.rows {
@@ -40,51 +104,53 @@ func (s *Screen) GetCurrentRow() *Row {
 		if buffor_size < s.term.cursor.y {
 			missingLines := s.term.cursor.y - buffor_size
 			for i := 0; i < missingLines; i++ {
-				s.buffor = append(s.buffor, Row{})
+				s.buffor = append(s.buffor, newRow())
 			}
 		}
 		return &s.buffor[s.term.cursor.y-1]
 	}
 }
 
-func (s *Screen) Truncate(rows int) {
-	var n int = len(s.buffor) - rows
-	if n > 0 {
-		s.buffor = s.buffor[n:]
-	}
-}
-
 func (s *Screen) Clear(mode int) {
 	switch mode {
 	case 2:
 		for i := 0; i < s.term.rows; i++ {
-			s.buffor = append(s.buffor, Row{})
+			s.buffor = append(s.buffor, newRow())
 		}
+		s.scroll()
 	case 3:
 		s.buffor = []Row{}
+		s.scrollback.Clear()
+		s.reset = true
 		s.term.cursor.y = 1
 		s.term.cursor.x = 1
 	}
 }
 
 func (s *Screen) String() string {
-	out := ""
+	var sb strings.Builder
 	active_row := s.GetCurrentRow()
-	for i, row := range s.buffor {
-		if active_row == &s.buffor[i] {
-			if s.term.cursorHidden {
-				out += row.Html()
-			} else {
-				out += row.HtmlWithCursor(s.term.cursor.x)
-			}
-		} else {
-			out += row.Html()
-		}
-		if i != len(s.buffor)-1 {
-			out += "\n"
-		}
+	for i := range s.Len() {
+		row := s.Row(i)
+		sb.WriteString(s.rowHtml(row, active_row == row, ""))
+	}
+	return sb.String()
+}
+
+// rowHtml renders the row as an element identified by the row id.
+func (s *Screen) rowHtml(row *Row, active bool, oob string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<span id=\"r%d\" class=\"row\"%s>", row.id, oob))
+	if row.HasMark(promptStart) {
+		sb.WriteString("<span class=\"prompt\"></span>")
+	}
+	if active && !s.term.cursorHidden {
+		sb.WriteString(row.HtmlWithCursor(s.term.cursor.x))
+	} else {
+		sb.WriteString(row.Html())
 	}
-	return out
+	sb.WriteString("</span>")
+	return sb.String()
 }
 
 func (s *Screen) Bytes() []byte {
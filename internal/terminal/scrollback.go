@@ -0,0 +1,253 @@
+package terminal
+
+import (
+	"encoding/binary"
+	"slices"
+	"unicode/utf8"
+)
+
+const DefaultScrollback = 1000
+
+// Scrollback keeps the rows scrolled off the top of the screen. They are not
+// changed anymore, so they are stored encoded in a ring buffer of limit rows.
+type Scrollback struct {
+	limit   int
+	start   int // index of the oldest row once the buffer is full
+	fresh   int // rows pushed since the last clean
+	dropped int // rows removed from the top by the limit
+	rows    []scrolledRow
+}
+
+type scrolledRow struct {
+	id    uint64
+	dirty bool // scrolled off before the change was sent to the browser
+	data  []byte
+}
+
+func (sb *Scrollback) Len() int {
+	return len(sb.rows)
+}
+
+func (sb *Scrollback) index(i int) int {
+	return (sb.start + i) % len(sb.rows)
+}
+
+// Row decodes the i-th row, counting from the oldest one.
+func (sb *Scrollback) Row(i int) Row {
+	scrolled := &sb.rows[sb.index(i)]
+	row := decodeRow(scrolled.data)
+	row.id = scrolled.id
+	row.dirty = scrolled.dirty
+	return row
+}
+
+func (sb *Scrollback) Push(row *Row) {
+	if sb.limit <= 0 {
+		sb.dropped++
+		return
+	}
+	scrolled := scrolledRow{id: row.id, dirty: row.dirty, data: encodeRow(row)}
+	sb.fresh++
+	if len(sb.rows) < sb.limit {
+		sb.rows = append(sb.rows, scrolled)
+	} else {
+		sb.rows[sb.start] = scrolled
+		sb.dropped++
+		sb.start = (sb.start + 1) % sb.limit
+	}
+}
+
+// Pop removes and returns the newest row.
+func (sb *Scrollback) Pop() Row {
+	sb.unwrap()
+	row := sb.Row(len(sb.rows) - 1)
+	sb.rows = sb.rows[:len(sb.rows)-1]
+	return row
+}
+
+// SetLimit changes the number of rows kept, dropping the oldest ones.
+func (sb *Scrollback) SetLimit(limit int) {
+	sb.unwrap()
+	if len(sb.rows) > limit {
+		sb.dropped += len(sb.rows) - max(limit, 0)
+		sb.rows = slices.Delete(sb.rows, 0, len(sb.rows)-max(limit, 0))
+	}
+	sb.limit = limit
+}
+
+func (sb *Scrollback) Clear() {
+	sb.rows = nil
+	sb.start = 0
+	sb.fresh = 0
+}
+
+// clean marks the rows as sent, only rows pushed since the last call can be dirty.
+func (sb *Scrollback) clean() {
+	for i := len(sb.rows) - min(sb.fresh, len(sb.rows)); i < len(sb.rows); i++ {
+		sb.rows[sb.index(i)].dirty = false
+	}
+	sb.fresh = 0
+}
+
+// unwrap moves the oldest row to the beginning of the slice.
+func (sb *Scrollback) unwrap() {
+	if sb.start != 0 {
+		sb.rows = append(sb.rows[sb.start:], sb.rows[:sb.start]...)
+		sb.start = 0
+	}
+}
+
+// row flags of the row encoding
+const (
+	encWrapped = 1 << iota
+)
+
+// style flags of the row encoding
+const (
+	encBold = 1 << iota
+	encDim
+	encItalic
+	encUnderline
+	encBlink
+	encInvert
+	encStrike
+	encBrightFg
+	encBrightBg
+	encRgbFg
+	encRgbBg
+	encLink
+)
+
+/*
+Row encoding, numbers are varints and strings a length followed by the bytes:
+
+	flags: row flags
+	marks: count { kind x exit_code }
+	attrs: count { gap length flags fg bg [fg_rgb] [bg_rgb] [link_id link_uri] }
+	text:  UTF-8 until the end
+
+Attribute positions are stored as the gap after the previous attribute and the length.
+*/
+func encodeRow(row *Row) []byte {
+	buf := make([]byte, 0, 4+len(row.text)+8*len(row.attrs)+4*len(row.marks))
+	var flags uint64
+	if row.wrapped {
+		flags |= encWrapped
+	}
+	buf = binary.AppendUvarint(buf, flags)
+	buf = binary.AppendUvarint(buf, uint64(len(row.marks)))
+	for _, mark := range row.marks {
+		buf = append(buf, mark.kind)
+		buf = binary.AppendVarint(buf, int64(mark.x))
+		buf = binary.AppendVarint(buf, int64(mark.exitCode))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(row.attrs)))
+	end := 0
+	for _, attr := range row.attrs {
+		s := &attr.style
+		var flags uint64
+		for i, set := range [...]bool{s.bold, s.dim, s.italic, s.underline, s.blink, s.invert, s.strike, s.brightFgColor, s.brightBgColor, s.rgbFgColor != nil, s.rgbBgColor != nil, s.link != nil} {
+			if set {
+				flags |= 1 << i
+			}
+		}
+		buf = binary.AppendVarint(buf, int64(attr.start-end-1))
+		buf = binary.AppendVarint(buf, int64(attr.end-attr.start+1))
+		end = attr.end
+		buf = binary.AppendUvarint(buf, flags)
+		buf = binary.AppendVarint(buf, int64(s.fgColor))
+		buf = binary.AppendVarint(buf, int64(s.bgColor))
+		for _, c := range [...]*RgbColor{s.rgbFgColor, s.rgbBgColor} {
+			if c != nil {
+				buf = append(buf, byte(c.r), byte(c.g), byte(c.b))
+			}
+		}
+		if s.link != nil {
+			buf = appendString(buf, s.link.id)
+			buf = appendString(buf, s.link.uri)
+		}
+	}
+	for _, r := range row.text {
+		buf = utf8.AppendRune(buf, r)
+	}
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+type rowDecoder struct {
+	buf []byte
+}
+
+func (d *rowDecoder) uint() int {
+	v, n := binary.Uvarint(d.buf)
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *rowDecoder) int() int {
+	v, n := binary.Varint(d.buf)
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *rowDecoder) string() string {
+	n := d.uint()
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *rowDecoder) rgb() *RgbColor {
+	c := &RgbColor{r: int(d.buf[0]), g: int(d.buf[1]), b: int(d.buf[2])}
+	d.buf = d.buf[3:]
+	return c
+}
+
+func decodeRow(data []byte) Row {
+	d := rowDecoder{buf: data}
+	row := Row{wrapped: d.uint()&encWrapped != 0}
+	if n := d.uint(); n > 0 {
+		row.marks = make([]PromptMark, n)
+		for i := range row.marks {
+			kind := d.buf[0]
+			d.buf = d.buf[1:]
+			row.marks[i] = PromptMark{kind: kind, x: d.int(), exitCode: d.int()}
+		}
+	}
+	end := 0
+	if n := d.uint(); n > 0 {
+		row.attrs = make([]Attr, n)
+		for i := range row.attrs {
+			start := end + 1 + d.int()
+			length := d.int()
+			flags := d.uint()
+			s := Style{fgColor: d.int(), bgColor: d.int()}
+			s.bold = flags&encBold != 0
+			s.dim = flags&encDim != 0
+			s.italic = flags&encItalic != 0
+			s.underline = flags&encUnderline != 0
+			s.blink = flags&encBlink != 0
+			s.invert = flags&encInvert != 0
+			s.strike = flags&encStrike != 0
+			s.brightFgColor = flags&encBrightFg != 0
+			s.brightBgColor = flags&encBrightBg != 0
+			if flags&encRgbFg != 0 {
+				s.rgbFgColor = d.rgb()
+			}
+			if flags&encRgbBg != 0 {
+				s.rgbBgColor = d.rgb()
+			}
+			if flags&encLink != 0 {
+				s.link = &Hyperlink{id: d.string(), uri: d.string()}
+			}
+			end = start + length - 1
+			row.attrs[i] = Attr{start: start, end: end, style: s}
+		}
+	}
+	row.text = []rune(string(d.buf))
+	return row
+}
@@ -0,0 +1,117 @@
+package terminal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRowEncoding(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\x1b]133;D;1\x07\x1b[1;31mżółć\x1b[0m \x1b[38;2;1;2;3;48;5;200mrgb\x1b[0m\x1b[10Cgap ")
+	process(term, "\x1b]8;id=x;https://example.com\x1b\\link\x1b]8;;\x1b\\")
+	row := term.screen.buffor[0]
+
+	result := decodeRow(encodeRow(&row))
+	result.id = row.id
+	result.dirty = row.dirty
+	if result.Html() != row.Html() || !reflect.DeepEqual(result.marks, row.marks) {
+		t.Errorf("Decoded row: %#q want: %#q", result.Html(), row.Html())
+	}
+}
+
+func TestScrollbackLimit(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(2, 80)
+	term.SetScrollback(3)
+	for i := range 10 {
+		process(term, fmt.Sprintf("%d\r\n", i))
+	}
+
+	if term.screen.scrollback.Len() != 3 || len(term.screen.buffor) != 2 {
+		t.Fatalf("Scrollback rows: %d screen rows: %d", term.screen.scrollback.Len(), len(term.screen.buffor))
+	}
+	for i, want := range []string{"6", "7", "8", "9", ""} {
+		if result := term.screen.Row(i).Text(); result != want {
+			t.Errorf("Row %d result: %#q want: %#q", i, result, want)
+		}
+	}
+
+	term.SetScrollback(1)
+	if result := term.screen.Row(0).Text(); result != "8" || term.screen.Len() != 3 {
+		t.Errorf("First row after limit change: %#q rows: %d", result, term.screen.Len())
+	}
+}
+
+func TestScrollbackResize(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(2, 80)
+	process(term, "a\r\nb\r\nc\r\nd")
+	term.SetSize(3, 80)
+
+	if len(term.screen.buffor) != 3 || term.screen.buffor[0].Text() != "b" {
+		t.Errorf("Screen after resize: %d rows, first %#q", len(term.screen.buffor), term.screen.buffor[0].Text())
+	}
+	if result := term.screen.GetCurrentRow().Text(); result != "d" {
+		t.Errorf("Cursor row after resize: %#q", result)
+	}
+}
+
+func TestScrollbackDirtyRows(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	term.SetSize(1, 80)
+	term.Update("code")
+
+	// the row is changed and scrolled off before the next update
+	process(term, "a\r\nb\r\nc")
+	update, _ := term.Update("code")
+	if update == "" {
+		t.Fatalf("Missing update")
+	}
+	if update, _ := term.Update("code"); update != "" {
+		t.Errorf("Scrollback rows sent again: %#q", update)
+	}
+}
+
+func TestScrollbackDroppedRows(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	term.SetSize(2, 80)
+	term.SetScrollback(3)
+	term.Update("code")
+	shown := term.screen.Row(0).id
+
+	for _, step := range []struct {
+		name    string
+		change  func()
+		removed int
+	}{
+		{"scroll past the limit", func() { process(term, "1\r\n2\r\n3\r\n4\r\n5\r\n6") }, 1},
+		{"lower limit", func() { term.SetScrollback(1) }, 2},
+		{"taller screen", func() { term.SetSize(3, 80) }, 0},
+		{"no scrollback", func() { term.SetScrollback(0); process(term, "\r\n7\r\n8") }, 2},
+	} {
+		step.change()
+		diff := term.diff()
+		if diff.full || len(diff.removed) != step.removed {
+			t.Errorf("%s: full: %v removed: %d want: %d", step.name, diff.full, len(diff.removed), step.removed)
+		}
+		if step.removed > 0 && diff.removed[0] != shown {
+			t.Errorf("%s: first removed row: %d want: %d", step.name, diff.removed[0], shown)
+		}
+		ids := []uint64{}
+		for i := range term.screen.Len() {
+			ids = append(ids, term.screen.rowId(i))
+		}
+		if !reflect.DeepEqual(term.render.ids, ids) {
+			t.Errorf("%s: rows shown: %v want: %v", step.name, term.render.ids, ids)
+		}
+		shown = ids[0]
+	}
+
+	term.SetSize(3, 40)
+	if diff := term.diff(); !diff.full {
+		t.Errorf("Reflow without a full render")
+	}
+}
@@ -0,0 +1,87 @@
+package terminal
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+const SearchLimit = 1000
+
+type SearchQuery struct {
+	Pattern       string
+	Regex         bool
+	CaseSensitive bool
+}
+
+// SearchMatch is a range of columns [Start, End) in a row, counted in characters from 0.
+// Line is the index of the row from the top of the scrollback.
+type SearchMatch struct {
+	Row   uint64 `json:"row"`
+	Line  int    `json:"line"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchResult lists the matches, Truncated is set when there were more than
+// SearchLimit.
+type SearchResult struct {
+	Matches   []SearchMatch `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+func (q *SearchQuery) compile() (*regexp.Regexp, error) {
+	if len(q.Pattern) == 0 {
+		return nil, errors.New("empty search pattern")
+	}
+	pattern := q.Pattern
+	if !q.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !q.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Search returns the matches on the current screen and its scrollback, from the oldest row.
+func (t *Terminal) Search(q SearchQuery) (SearchResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.GetScreen().Search(q, SearchLimit)
+}
+
+// Search returns up to limit matches, rows are searched separately. The newest
+// rows are searched first, a truncated result lacks the oldest matches.
+func (s *Screen) Search(q SearchQuery, limit int) (SearchResult, error) {
+	re, err := q.compile()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	matches := []SearchMatch{} // from the newest
+	for i := s.Len() - 1; i >= 0; i-- {
+		row := s.Row(i)
+		text := row.Text()
+		locs := re.FindAllStringIndex(text, -1)
+		for j := len(locs) - 1; j >= 0; j-- {
+			loc := locs[j]
+			if loc[0] == loc[1] {
+				continue
+			}
+			if len(matches) == limit {
+				slices.Reverse(matches)
+				return SearchResult{Matches: matches, Truncated: true}, nil
+			}
+			start := utf8.RuneCountInString(text[:loc[0]])
+			matches = append(matches, SearchMatch{
+				Row:   row.id,
+				Line:  i,
+				Start: start,
+				End:   start + utf8.RuneCountInString(text[loc[0]:loc[1]]),
+			})
+		}
+	}
+	slices.Reverse(matches)
+	return SearchResult{Matches: matches}, nil
+}
@@ -0,0 +1,62 @@
+package terminal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(2, 80)
+	process(term, "zażółć Error\r\nok\r\nerror: \x1b[1mERROR\x1b[0m\r\n")
+
+	result, err := term.Search(SearchQuery{Pattern: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := result.Matches
+	want := []SearchMatch{
+		{Row: term.screen.rowId(0), Line: 0, Start: 7, End: 12},
+		{Row: term.screen.rowId(2), Line: 2, Start: 0, End: 5},
+		{Row: term.screen.rowId(2), Line: 2, Start: 7, End: 12},
+	}
+	if !reflect.DeepEqual(matches, want) || result.Truncated {
+		t.Errorf("Search result: %v want: %v", result, want)
+	}
+
+	result, _ = term.Search(SearchQuery{Pattern: "ERROR", CaseSensitive: true})
+	if len(result.Matches) != 1 || result.Matches[0].Start != 7 {
+		t.Errorf("Case sensitive search result: %v", result)
+	}
+	result, _ = term.Search(SearchQuery{Pattern: "^[a-z]+$", Regex: true})
+	if len(result.Matches) != 1 || result.Matches[0].Line != 1 {
+		t.Errorf("Regex search result: %v", result)
+	}
+	result, _ = term.Search(SearchQuery{Pattern: "a.c"})
+	if len(result.Matches) != 0 {
+		t.Errorf("Plain text search should not use regex: %v", result)
+	}
+	if _, err := term.Search(SearchQuery{Pattern: "(", Regex: true}); err == nil {
+		t.Errorf("Invalid regex should return an error")
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetSize(2, 80)
+	process(term, "a a a\r\na")
+
+	result, _ := term.GetScreen().Search(SearchQuery{Pattern: "a"}, 3)
+	want := []SearchMatch{
+		{Row: term.screen.rowId(0), Line: 0, Start: 2, End: 3},
+		{Row: term.screen.rowId(0), Line: 0, Start: 4, End: 5},
+		{Row: term.screen.rowId(1), Line: 1, Start: 0, End: 1},
+	}
+	if !reflect.DeepEqual(result.Matches, want) || !result.Truncated {
+		t.Errorf("Search over the limit: %v want the newest: %v", result, want)
+	}
+	result, _ = term.GetScreen().Search(SearchQuery{Pattern: "a"}, 4)
+	if len(result.Matches) != 4 || result.Truncated {
+		t.Errorf("Search up to the limit: %v", result)
+	}
+}
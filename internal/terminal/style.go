@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -47,6 +48,28 @@ type RgbColor struct {
 	r, g, b int
 }
 
+// Hyperlink is an OSC 8 link target shared by all cells printed while it is open.
+type Hyperlink struct {
+	id  string
+	uri string
+}
+
+var allowedSchemes = []string{"http", "https", "ftp", "mailto"}
+
+// Href returns the link target if it is safe to put into an <a> element.
+func (h *Hyperlink) Href() (string, bool) {
+	u, err := url.Parse(h.uri)
+	if err != nil {
+		return "", false
+	}
+	for _, scheme := range allowedSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
 type Style struct {
 	bold          bool
 	dim           bool
@@ -61,6 +84,7 @@ type Style struct {
 	bgColor       int
 	rgbFgColor    *RgbColor
 	rgbBgColor    *RgbColor
+	link          *Hyperlink
 }
 
 func NewStyle() Style {
@@ -80,7 +104,15 @@ func (s *Style) IsEmpty() bool {
 		s.fgColor == -1 &&
 		s.bgColor == -1 &&
 		s.rgbFgColor == nil &&
-		s.rgbBgColor == nil
+		s.rgbBgColor == nil &&
+		s.link == nil
+}
+
+// HasLook reports whether the style changes how the text looks, ignoring links.
+func (s *Style) HasLook() bool {
+	look := *s
+	look.link = nil
+	return !look.IsEmpty()
 }
 
 func (s Style) Add(sgr int) Style {
@@ -285,23 +317,28 @@ func (s *Style) Attributes() string {
 		html = "class=\"" + strings.Join(classes, " ") + "\""
 	}
 
-	if s.rgbFgColor != nil || s.rgbBgColor != nil {
+	if css := s.Css(); len(css) > 0 {
 		if len(html) > 0 {
 			html += " "
 		}
-		html += "style=\""
-		if s.rgbFgColor != nil {
-			html += fmt.Sprintf("color: rgb(%d,%d,%d);", s.rgbFgColor.r, s.rgbFgColor.g, s.rgbFgColor.b)
-		}
-		if s.rgbBgColor != nil {
-			html += fmt.Sprintf("background-color: rgb(%d,%d,%d);", s.rgbBgColor.r, s.rgbBgColor.g, s.rgbBgColor.b)
-		}
-		html += "\""
+		html += "style=\"" + css + "\""
 	}
 
 	return html
 }
 
+// Css returns the inline style for 8 and 24 bit colors.
+func (s *Style) Css() string {
+	css := ""
+	if s.rgbFgColor != nil {
+		css += fmt.Sprintf("color: rgb(%d,%d,%d);", s.rgbFgColor.r, s.rgbFgColor.g, s.rgbFgColor.b)
+	}
+	if s.rgbBgColor != nil {
+		css += fmt.Sprintf("background-color: rgb(%d,%d,%d);", s.rgbBgColor.r, s.rgbBgColor.g, s.rgbBgColor.b)
+	}
+	return css
+}
+
 func (s *Style) Classes() string {
 	out := []string{}
 	if s.bold {
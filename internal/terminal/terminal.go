@@ -3,14 +3,24 @@ package terminal
 import (
 	"fmt"
 	"io"
+	"potatossh/internal/theme"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// Terminal is safe for concurrent use through the methods which lock mu,
+// the remaining exported methods are executed while processing the output.
 type Terminal struct {
+	mu               sync.Mutex
+	partial          []byte // incomplete UTF-8 sequence at the end of the last Write
 	stdin            io.WriteCloser
 	connected        bool
 	title            string
+	cwd              string
 	staticTitle      string
-	TitleUpdate      bool
+	titleUpdate      bool
 	rows             int
 	columns          int
 	eState           EscapeState
@@ -19,8 +29,15 @@ type Terminal struct {
 	cursorMemory     Cursor
 	cursorHidden     bool
 	altScreenEnabled bool
+	bracketedPaste   bool // pasted text is sent between ESC [200~ and ESC [201~
 	screen           *Screen
 	altScreen        *Screen
+	clipboard        []ClipboardRequest
+	theme            *theme.Theme
+	palette          Palette
+	bell             bool
+	lastBell         time.Time
+	render           renderState
 }
 
 func NewTerminal(title string) *Terminal {
@@ -29,7 +46,7 @@ func NewTerminal(title string) *Terminal {
 		connected:        false,
 		title:            title,
 		staticTitle:      "",
-		TitleUpdate:      false,
+		titleUpdate:      false,
 		rows:             40,
 		columns:          80,
 		eState:           NewEscapeState(),
@@ -39,17 +56,59 @@ func NewTerminal(title string) *Terminal {
 		cursorHidden:     false,
 		altScreenEnabled: false,
 	}
-	term.screen = &Screen{term: term}
+	term.screen = &Screen{term: term, scrollback: Scrollback{limit: DefaultScrollback}}
 	term.altScreen = &Screen{term: term}
 	return term
 }
 
+// BracketedPaste returns true when the remote program enabled the bracketed paste mode.
+func (t *Terminal) BracketedPaste() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bracketedPaste
+}
+
 func (t *Terminal) Connected(stdin io.WriteCloser) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.stdin = stdin
 	t.connected = true
 }
 
+// Write processes a chunk of the output. An incomplete UTF-8 sequence at the end
+// of p is kept for the next call, invalid bytes are replaced with U+FFFD.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if len(t.partial) > 0 {
+		p = append(t.partial, p...)
+		t.partial = nil
+	}
+	for len(p) > 0 {
+		if p[0] < utf8.RuneSelf {
+			t.processCharacter(rune(p[0]))
+			p = p[1:]
+			continue
+		}
+		if !utf8.FullRune(p) {
+			t.partial = append([]byte(nil), p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		t.processCharacter(r)
+		p = p[size:]
+	}
+	return n, nil
+}
+
 func (t *Terminal) ProcessCharacter(r rune) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.processCharacter(r)
+}
+
+func (t *Terminal) processCharacter(r rune) {
 	screen := t.GetScreen()
 	if t.ProcessEscape(r) {
 		return
@@ -62,18 +121,26 @@ func (t *Terminal) ProcessCharacter(r rune) {
 			t.cursor.x--
 		}
 	case '\a':
-		fmt.Println("BELL")
+		t.Bell()
 	case '\n':
+		screen.GetCurrentRow().wrapped = false
 		screen.MoveToNextLine()
 	default:
 		active_row := screen.GetCurrentRow()
 		if t.cursor.x > t.columns { // full row
+			active_row.wrapped = true
 			active_row = screen.MoveToNextLine()
 		}
 		active_row.AddText(r, t.cursor.x, &t.style)
 		t.cursor.x++
 	}
-	screen.Truncate(500)
+}
+
+// SetScrollback sets the number of rows kept above the main screen.
+func (t *Terminal) SetScrollback(rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.screen.scrollback.SetLimit(rows)
 }
 
 func (t *Terminal) GetScreen() *Screen {
@@ -84,11 +151,22 @@ func (t *Terminal) GetScreen() *Screen {
 }
 
 func (t *Terminal) SetSize(rows, cols int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if rows != t.rows || cols != t.columns {
+		if cols != t.columns && t.altScreenEnabled {
+			// the main screen is reflowed with its saved cursor
+			t.cursor, t.cursorMemory = t.cursorMemory, t.cursor
+			t.screen.reflow(cols)
+			t.cursor, t.cursorMemory = t.cursorMemory, t.cursor
+		} else if cols != t.columns {
+			t.screen.reflow(cols)
+		}
 		if t.cursor.y > rows {
 			t.cursor.y = rows
 		}
-		if len(t.GetScreen().buffor) >= rows && rows > t.rows {
+		if screen := t.GetScreen(); screen.Len() >= rows && rows > t.rows {
+			screen.unscroll(rows - len(screen.buffor))
 			t.cursor.y += rows - t.rows
 		}
 		t.rows = rows
@@ -99,10 +177,18 @@ func (t *Terminal) SetSize(rows, cols int) bool {
 }
 
 func (t *Terminal) GetSize() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.rows, t.columns
 }
 
 func (t *Terminal) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.string()
+}
+
+func (t *Terminal) string() string {
 	if !t.connected {
 		return "connecting..."
 	}
@@ -115,6 +201,8 @@ func (t *Terminal) Bytes() []byte {
 }
 
 func (t *Terminal) Title() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if len(t.staticTitle) == 0 {
 		return t.title
 	}
@@ -125,12 +213,23 @@ func (t *Terminal) SetTitle(title string) {
 	if t.title != title {
 		t.title = title
 		if len(t.staticTitle) == 0 {
-			t.TitleUpdate = true
+			t.titleUpdate = true
 		}
 	}
 }
 
+// TakeTitleUpdate reports whether the title changed since the last call.
+func (t *Terminal) TakeTitleUpdate() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	update := t.titleUpdate
+	t.titleUpdate = false
+	return update
+}
+
 func (t *Terminal) SetStaticTitle(title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.staticTitle = title
 }
 
@@ -173,3 +272,39 @@ func (t *Terminal) SaveCursor() {
 func (t *Terminal) RestoreCursor() {
 	t.cursor = t.cursorMemory
 }
+
+// SetHyperlink opens the OSC 8 hyperlink for the following text, an empty uri closes it.
+func (t *Terminal) SetHyperlink(params, uri string) {
+	if len(uri) == 0 {
+		t.style.link = nil
+		return
+	}
+	link := &Hyperlink{uri: uri}
+	for _, param := range strings.Split(params, ":") {
+		if id, ok := strings.CutPrefix(param, "id="); ok {
+			link.id = id
+		}
+	}
+	t.style.link = link
+}
+
+// Bells closer than this are reported once.
+const BellInterval = time.Second
+
+func (t *Terminal) Bell() {
+	now := time.Now()
+	if now.Sub(t.lastBell) < BellInterval {
+		return
+	}
+	t.lastBell = now
+	t.bell = true
+}
+
+// TakeBell reports whether the bell rang since the last call.
+func (t *Terminal) TakeBell() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bell := t.bell
+	t.bell = false
+	return bell
+}
@@ -0,0 +1,238 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"potatossh/internal/theme"
+	"testing"
+)
+
+func process(term *Terminal, input string) {
+	for _, r := range input {
+		term.ProcessCharacter(r)
+	}
+}
+
+func TestCwd(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\x1b]7;file://host/home/user/my%20dir\x07")
+
+	result := term.Cwd()
+	want := "/home/user/my dir"
+
+	if result != want {
+		t.Errorf("Cwd result: %#q want: %#q", result, want)
+	}
+	if !term.titleUpdate {
+		t.Errorf("Cwd change should update the title")
+	}
+	process(term, "\x1b]7;file://h/a%22%3E%3Cimg src=x%3E\x07")
+	process(term, "\x1b]7;file://h/a%0Ab\x07")
+	if term.Cwd() != want {
+		t.Errorf("Cwd with markup or control characters accepted: %#q", term.Cwd())
+	}
+}
+
+func TestLastCommandOutput(t *testing.T) {
+	term := NewTerminal("test")
+	prompt := "\x1b]133;A\x07$ \x1b]133;B\x07"
+	process(term, prompt+"ls\r\n\x1b]133;C\x07a.txt\r\nb.txt\r\n\x1b]133;D;0\x07")
+	process(term, prompt+"false\r\n\x1b]133;C\x07\x1b]133;D;1\x07")
+	process(term, prompt+"echo hi\r\n\x1b]133;C\x07hi\r\n\x1b]133;D;0\x07"+prompt)
+
+	result, ok := term.LastCommandOutput()
+	want := "hi"
+
+	if !ok || result != want {
+		t.Errorf("Output result: %#q want: %#q", result, want)
+	}
+
+	rows := 0
+	for _, row := range term.screen.buffor {
+		if row.HasMark(promptStart) {
+			rows++
+		}
+	}
+	if rows != 4 {
+		t.Errorf("Prompt rows: %d want: %d", rows, 4)
+	}
+}
+
+func TestLastCommandOutputMultiline(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\x1b]133;A\x07$ \x1b]133;B\x07ls\r\n\x1b]133;C\x07a.txt\r\nb.txt\r\n\x1b]133;D;0\x07")
+
+	result, ok := term.LastCommandOutput()
+	want := "a.txt\nb.txt"
+
+	if !ok || result != want {
+		t.Errorf("Output result: %#q want: %#q", result, want)
+	}
+}
+
+type stdinRecorder struct {
+	bytes.Buffer
+}
+
+func (r *stdinRecorder) Close() error {
+	return nil
+}
+
+func TestColorQueries(t *testing.T) {
+	term := NewTerminal("test")
+	stdin := &stdinRecorder{}
+	term.Connected(stdin)
+	term.SetTheme(&theme.Theme{Background: theme.Color{R: 0x28, G: 0x2a, B: 0x36}, Red: theme.Color{R: 0xff}})
+
+	process(term, "\x1b]11;?\x1b\\\x1b]4;1;?;196;?\x07")
+
+	result := stdin.String()
+	want := "\x1b]11;rgb:2828/2a2a/3636\x1b\\\x1b]4;1;rgb:ffff/0000/0000\x07\x1b]4;196;rgb:ffff/0000/0000\x07"
+
+	if result != want {
+		t.Errorf("Reply result: %#q want: %#q", result, want)
+	}
+}
+
+func TestPaletteOverrides(t *testing.T) {
+	term := NewTerminal("test")
+	term.SetTheme(&theme.Theme{})
+
+	process(term, "\x1b]4;1;rgb:ff/80/00;9;#00ff00;196;#0000ff\x07\x1b]11;rgb:ffff/ffff/ffff\x07")
+	if c, _ := term.Color(196); c != (theme.Color{R: 255}) {
+		t.Errorf("Color 196 changed: %v", c)
+	}
+
+	result := term.PaletteStyle()
+	want := "--red: #FF8000;--bred: #00FF00;background-color: #FFFFFF;"
+
+	if result != want {
+		t.Errorf("Style result: %#q want: %#q", result, want)
+	}
+
+	process(term, "\x1b]104;1\x07\x1b]111\x07")
+
+	result = term.PaletteStyle()
+	want = "--bred: #00FF00;"
+
+	if result != want {
+		t.Errorf("Style result: %#q want: %#q", result, want)
+	}
+}
+
+func TestBellRateLimit(t *testing.T) {
+	term := NewTerminal("test")
+	process(term, "\a\a\a")
+
+	if !term.TakeBell() {
+		t.Errorf("Bell not reported")
+	}
+
+	process(term, "\a")
+
+	if term.TakeBell() {
+		t.Errorf("Bell reported within %v", BellInterval)
+	}
+}
+
+func TestUpdateRows(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	term.SetSize(2, 80)
+	process(term, "ab\r\ncd")
+
+	if update, full := term.Update("code"); !full || update != `<code id="code" style="">`+term.String()+`</code>` {
+		t.Fatalf("First update: %#q full: %v", update, full)
+	}
+	if update, full := term.Update("code"); full || update != "" {
+		t.Errorf("Update without changes: %#q full: %v", update, full)
+	}
+
+	process(term, "e")
+	id := term.screen.buffor[1].id
+	update, _ := term.Update("code")
+	want := fmt.Sprintf("<span id=\"r%d\" class=\"row\" hx-swap-oob=\"true\">cde<span class=\"cursor\"> </span></span>", id)
+	if update != want {
+		t.Errorf("Update result: %#q want: %#q", update, want)
+	}
+
+	process(term, "\r\nf")
+	update, _ = term.Update("code")
+	want = fmt.Sprintf("<span id=\"r%d\" class=\"row\" hx-swap-oob=\"true\">cde</span><div hx-swap-oob=\"beforeend:#code\"><span id=\"r%d\" class=\"row\">f<span class=\"cursor\"> </span></span></div>", id, id+1)
+	if update != want {
+		t.Errorf("Update result: %#q want: %#q", update, want)
+	}
+
+	first := term.screen.rowId(0)
+	term.SetScrollback(0)
+	update, _ = term.Update("code")
+	want = fmt.Sprintf("<span id=\"r%d\" hx-swap-oob=\"delete\"></span>", first)
+	if update != want {
+		t.Errorf("Update result: %#q want: %#q", update, want)
+	}
+}
+
+func TestUpdateAltScreen(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	term.Update("code")
+
+	process(term, "\x1b[?1049h")
+
+	if _, full := term.Update("code"); !full {
+		t.Errorf("Switching screens should render everything")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	term := NewTerminal("test")
+	term.Connected(&stdinRecorder{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			process(term, fmt.Sprintf("\x1b]0;title %d\x07line %d\r\n\x1b]11;?\x07\x07", i, i))
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			term.Update("session_test")
+			term.UpdateBinary()
+			_ = term.String()
+			term.Title()
+			term.TakeTitleUpdate()
+			term.TakeBell()
+			term.PaletteStyle()
+			term.SetSize(24, 80)
+		}
+	}
+}
+
+func TestWriteSplitUtf8(t *testing.T) {
+	input := []byte("zażółć ✓ 🥔")
+	for split := range len(input) {
+		term := NewTerminal("test")
+		term.Write(input[:split])
+		term.Write(input[split:])
+
+		result := term.screen.buffor[0].Text()
+		if result != string(input) {
+			t.Errorf("Split at %d result: %#q want: %#q", split, result, input)
+		}
+	}
+}
+
+func TestWriteInvalidUtf8(t *testing.T) {
+	term := NewTerminal("test")
+	term.Write([]byte("a\xffb\xe2\x9c"))
+	term.Write([]byte("c"))
+
+	result := term.screen.buffor[0].Text()
+	want := "a�b��c"
+	if result != want {
+		t.Errorf("Invalid UTF-8 result: %#q want: %#q", result, want)
+	}
+}
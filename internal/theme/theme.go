@@ -37,6 +37,18 @@ type Theme struct {
 	BrightWhite  Color  `json:"brightWhite"`
 }
 
+// Ansi returns one of the 16 base colors (0-7 normal, 8-15 bright).
+func (t *Theme) Ansi(n int) (Color, bool) {
+	colors := []Color{
+		t.Black, t.Red, t.Green, t.Yellow, t.Blue, t.Purple, t.Cyan, t.White,
+		t.BrightBlack, t.BrightRed, t.BrightGreen, t.BrightYellow, t.BrightBlue, t.BrightPurple, t.BrightCyan, t.BrightWhite,
+	}
+	if n < 0 || n >= len(colors) {
+		return Color{}, false
+	}
+	return colors[n], true
+}
+
 func (f *Color) UnmarshalJSON(b []byte) error {
 	if len(b) != 9 {
 		// If the input data is empty, let's do nothing and throw an error
@@ -0,0 +1,200 @@
+// UploadFiles sends the files selected in the file browser, one request per file,
+// with a progress bar and a cancel button. Existing files are handled like dropped
+// ones. The directory is listed again when all are done.
+async function UploadFiles(input) {
+    let panel = input.closest(".files")
+    let sessionId = panel.dataset.session
+    let dir = panel.dataset.dir
+    let transfers = panel.querySelector(".transfers")
+    let files = Array.from(input.files)
+    input.value = ""
+
+    let params = new URLSearchParams({"path": dir})
+    files.forEach(file => params.append("name", file.name))
+    let response = await fetch(`/files/${sessionId}/exists?${params}`)
+    if (!response.ok) {
+        alert("Upload unavailable: " + await response.text())
+        return
+    }
+    let existing = await response.json()
+    let all = null // conflict choice applied to the remaining files
+    let uploads = []
+    for (const file of files) {
+        let conflict = "rename"
+        if (existing.includes(file.name)) {
+            conflict = all
+            if (conflict == null) {
+                let choice = await Drop.AskConflict(file.name, dir)
+                conflict = choice.conflict
+                if (choice.all) {
+                    all = conflict
+                }
+            }
+        }
+        if (conflict != "skip") {
+            uploads.push({file: file, conflict: conflict})
+        }
+    }
+
+    let pending = uploads.length
+    uploads.forEach(({file, conflict}) => {
+        let item = document.createElement("li")
+        item.innerHTML = `<span></span> <progress max="100" value="0"></progress> <button title="Cancel">✖</button>`
+        item.querySelector("span").textContent = file.name
+        transfers.appendChild(item)
+
+        let data = new FormData()
+        data.append("file", file)
+        let request = new XMLHttpRequest()
+        request.open("POST", "/files/" + sessionId + "/upload?" + new URLSearchParams({"path": dir, "conflict": conflict}))
+        request.upload.onprogress = function(e) {
+            if (e.lengthComputable) {
+                item.querySelector("progress").value = e.loaded / e.total * 100
+            }
+        }
+        request.onloadend = function() {
+            if (request.status != 200) {
+                item.querySelector("span").textContent = file.name + ": " + (request.responseText || "canceled")
+                item.querySelector("progress").remove()
+                item.querySelector("button").onclick = () => item.remove()
+            } else {
+                item.remove()
+            }
+            pending--
+            if (pending == 0 && document.body.contains(panel)) {
+                htmx.ajax("GET", "/files/" + sessionId + "?path=" + encodeURIComponent(dir), {target: "#files_" + sessionId, swap: "outerHTML"})
+            }
+        }
+        item.querySelector("button").onclick = () => request.abort()
+        request.send(data)
+    })
+}
+
+// Drop uploads the files dropped onto a tab to the remote working directory,
+// the server reports the progress with upload events on the websocket.
+class Drop {
+    constructor(terminal) {
+        this.sessionId = terminal.session_id.replace("session_", "")
+        this.tab = terminal.tabElement
+        this.list = null
+        this.items = new Map()
+        this.tab.addEventListener("dragover", e => {
+            if (e.dataTransfer.types.includes("Files")) {
+                e.preventDefault()
+                this.tab.classList.add("dragover")
+            }
+        })
+        this.tab.addEventListener("dragleave", e => {
+            if (!this.tab.contains(e.relatedTarget)) {
+                this.tab.classList.remove("dragover")
+            }
+        })
+        this.tab.addEventListener("drop", e => {
+            this.tab.classList.remove("dragover")
+            if (e.dataTransfer.files.length > 0) {
+                e.preventDefault()
+                this.Upload(Array.from(e.dataTransfer.files))
+            }
+        })
+    }
+
+    async Upload(files) {
+        let params = new URLSearchParams()
+        files.forEach(file => params.append("name", file.name))
+        let response = await fetch(`/upload/${this.sessionId}?${params}`)
+        if (!response.ok) {
+            alert("Upload unavailable: " + await response.text())
+            return
+        }
+        let info = await response.json()
+        let all = null // conflict choice applied to the remaining files
+        for (const file of files) {
+            if (info.limit > 0 && file.size > info.limit) {
+                this.Finish(this.Item(file.name), `${file.name}: larger than ${info.limit / 1024 / 1024} MiB`, false)
+                continue
+            }
+            let conflict = "rename"
+            if (info.existing.includes(file.name)) {
+                conflict = all
+                if (conflict == null) {
+                    let choice = await Drop.AskConflict(file.name, info.dir)
+                    conflict = choice.conflict
+                    if (choice.all) {
+                        all = conflict
+                    }
+                }
+            }
+            if (conflict != "skip") {
+                this.Send(file, conflict)
+            }
+        }
+    }
+
+    Send(file, conflict) {
+        let id = Math.random().toString(36).slice(2)
+        let item = this.Item(file.name)
+        let controller = new AbortController()
+        item.querySelector("button").onclick = () => controller.abort()
+        this.items.set(id, item)
+        let params = new URLSearchParams({"id": id, "name": file.name, "size": file.size, "conflict": conflict})
+        fetch(`/upload/${this.sessionId}?${params}`, {method: "POST", body: file, signal: controller.signal})
+            .then(async response => this.Finish(item, `${file.name}: ${response.ok ? "uploaded to " : ""}${await response.text()}`, response.ok))
+            .catch(() => this.Finish(item, `${file.name}: canceled`, false))
+            .finally(() => this.items.delete(id))
+    }
+
+    // Progress handles the upload events of the server.
+    Progress(event) {
+        let item = this.items.get(event.id)
+        if (item != null && event.size > 0) {
+            let progress = item.querySelector("progress")
+            if (progress != null) {
+                progress.value = event.sent / event.size * 100
+            }
+        }
+    }
+
+    Item(name) {
+        if (this.list == null || !this.tab.contains(this.list)) {
+            this.list = document.createElement("ul")
+            this.list.className = "uploads"
+            this.tab.prepend(this.list)
+        }
+        let item = document.createElement("li")
+        item.innerHTML = `<span></span> <progress max="100" value="0"></progress> <button title="Cancel">✖</button>`
+        item.querySelector("span").textContent = name
+        this.list.appendChild(item)
+        return item
+    }
+
+    Finish(item, text, ok) {
+        item.querySelector("span").textContent = text
+        item.querySelector("progress")?.remove()
+        let remove = () => {
+            item.remove()
+            if (this.list != null && this.list.children.length == 0) {
+                this.list.remove()
+                this.list = null
+            }
+        }
+        item.querySelector("button").onclick = remove
+        if (ok) {
+            setTimeout(remove, 3000)
+        }
+    }
+
+    // AskConflict asks what to do with a file which already exists.
+    static AskConflict(name, dir) {
+        let dialog = document.getElementById("conflict_dialog")
+        dialog.querySelector(".name").textContent = name
+        dialog.querySelector(".dir").textContent = dir
+        dialog.querySelector("[name='all']").checked = false
+        dialog.returnValue = ""
+        return new Promise(resolve => {
+            dialog.addEventListener("close", () => {
+                resolve({conflict: dialog.returnValue || "skip", all: dialog.querySelector("[name='all']").checked})
+            }, {once: true})
+            dialog.showModal()
+        })
+    }
+}
@@ -1,12 +1,16 @@
 class Keyboard {
-    constructor(tabElement, keyFunc, enterPressed) {
+    constructor(tabElement, keyFunc, enterPressed, shortcutFunc) {
         this.tabElement = tabElement
         this.keyFunc = keyFunc;
         this.enterPressed = enterPressed
+        this.shortcutFunc = shortcutFunc
         this.enable_listeners()
     }
     enable_listeners() {
         document.addEventListener('keydown', e => {
+            if (e.target.closest != null && e.target.closest(".search, .files, .forwards, .exec, dialog") != null) {
+                return // typing into the search bar, a panel or a dialog
+            }
             console.log(this.tabElement.previousSibling.previousSibling.checked)
             let active = this.tabElement.parentNode.classList.contains("active") && this.tabElement.previousElementSibling.checked
             let keyPressed = false;
@@ -63,6 +67,22 @@ class Keyboard {
                         this.keyFunc("\u001a")
                         keyPressed = true;
                     }
+                    else if (e.shiftKey && e.key == "ArrowUp") {
+                        this.shortcutFunc("prompt_previous")
+                        keyPressed = true;
+                    }
+                    else if (e.shiftKey && e.key == "ArrowDown") {
+                        this.shortcutFunc("prompt_next")
+                        keyPressed = true;
+                    }
+                    else if (e.key == "O") {
+                        this.shortcutFunc("copy_output")
+                        keyPressed = true;
+                    }
+                    else if (e.key == "F") {
+                        this.shortcutFunc("search")
+                        keyPressed = true;
+                    }
                     else if (e.key == "C") {
                         var sel = window.getSelection();
                         if (sel.rangeCount > 0) {
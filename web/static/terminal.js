@@ -16,6 +16,320 @@ document.addEventListener("DOMContentLoaded", function(event) {
   UpdateFontDimensions();
 });
 
+function CopyToClipboard(text) {
+    if (navigator.clipboard) {
+        return navigator.clipboard.writeText(text);
+    }
+    // no Clipboard API without HTTPS
+    const textarea = document.createElement("textarea");
+    textarea.value = text;
+    document.body.appendChild(textarea);
+    textarea.select();
+    document.execCommand("copy");
+    document.body.removeChild(textarea);
+    return Promise.resolve();
+}
+
+function RequestNotifications() {
+    if ("Notification" in window && Notification.permission == "default") {
+        Notification.requestPermission();
+    }
+}
+
+function Beep() {
+    const audio = new AudioContext();
+    const oscillator = audio.createOscillator();
+    const gain = audio.createGain();
+    oscillator.frequency.value = 880;
+    gain.gain.value = 0.1;
+    oscillator.connect(gain);
+    gain.connect(audio.destination);
+    oscillator.start();
+    oscillator.stop(audio.currentTime + 0.1);
+    oscillator.onended = () => audio.close();
+}
+
+// Decoder of the binary cell-grid protocol (see internal/terminal/binary.go).
+class BinaryRenderer {
+
+    constructor() {
+        this.styles = new Map();
+        this.decoder = new TextDecoder();
+    }
+
+    Apply(element, buffer) {
+        this.data = new Uint8Array(buffer);
+        this.offset = 3;
+        if (this.data[0] != 80 || this.data[1] != 1) { // 'P', version 1
+            console.error("Unknown binary message");
+            return;
+        }
+        const flags = this.data[2];
+        if (flags & 1) { // full
+            element.innerHTML = "";
+            element.style = this.String();
+            this.styles.clear();
+        }
+        if (!(flags & 2)) { // not connected
+            element.textContent = "connecting...";
+        }
+        const cursorHidden = flags & 4;
+        const cursorRow = this.Uint();
+        const cursorX = this.Uint();
+        for (let count = this.Uint(); count > 0; count--) {
+            const id = this.Uint();
+            this.styles.set(id, {classes: this.String(), css: this.String(), href: this.String(), linkId: this.String()});
+        }
+        for (let count = this.Uint(); count > 0; count--) {
+            const row = document.getElementById("r" + this.Uint());
+            if (row != null) {
+                row.remove();
+            }
+        }
+        for (let count = this.Uint(); count > 0; count--) {
+            const id = this.Uint();
+            const rowFlags = this.Uint();
+            const row = document.createElement("span");
+            row.id = "r" + id;
+            row.className = "row";
+            if (rowFlags & 1) {
+                const prompt = document.createElement("span");
+                prompt.className = "prompt";
+                row.appendChild(prompt);
+            }
+            let cursor = (id == cursorRow && !cursorHidden) ? cursorX - 1 : -1;
+            for (let runs = this.Uint(); runs > 0; runs--) {
+                const style = this.styles.get(this.Uint());
+                const text = Array.from(this.String());
+                if (cursor >= 0 && cursor < text.length) {
+                    this.AddRun(row, style, text.slice(0, cursor).join(""));
+                    this.AddRun(row, style, text[cursor], true);
+                    this.AddRun(row, style, text.slice(cursor + 1).join(""));
+                } else {
+                    this.AddRun(row, style, text.join(""));
+                }
+                cursor -= text.length;
+            }
+            if (cursor >= 0) {
+                this.AddRun(row, undefined, " ".repeat(cursor));
+                this.AddRun(row, undefined, " ", true);
+            }
+            const old = document.getElementById(row.id);
+            if (old != null) {
+                old.replaceWith(row);
+            } else {
+                element.appendChild(row);
+            }
+        }
+    }
+
+    AddRun(row, style, text, cursor) {
+        if (text.length == 0) {
+            return;
+        }
+        let parent = row;
+        if (style !== undefined && style.href.length > 0) {
+            const link = document.createElement("a");
+            link.href = style.href;
+            link.target = "_blank";
+            link.rel = "noopener noreferrer";
+            if (style.linkId.length > 0) {
+                link.dataset.linkId = style.linkId;
+            }
+            parent.appendChild(link);
+            parent = link;
+        }
+        if (style !== undefined && (style.classes.length > 0 || style.css.length > 0)) {
+            const span = document.createElement("span");
+            span.className = style.classes;
+            span.style = style.css;
+            parent.appendChild(span);
+            parent = span;
+        }
+        if (cursor) {
+            const span = document.createElement("span");
+            span.className = "cursor";
+            parent.appendChild(span);
+            parent = span;
+        }
+        parent.appendChild(document.createTextNode(text));
+    }
+
+    Uint() {
+        let value = 0;
+        let shift = 1;
+        let byte;
+        do {
+            byte = this.data[this.offset++];
+            value += (byte & 0x7f) * shift;
+            shift *= 128;
+        } while (byte & 0x80);
+        return value;
+    }
+
+    String() {
+        const length = this.Uint();
+        const text = this.decoder.decode(this.data.subarray(this.offset, this.offset + length));
+        this.offset += length;
+        return text;
+    }
+}
+
+// Search highlights the matches found by the server in the terminal rows,
+// the highlights are drawn with the CSS Custom Highlight API.
+class Search {
+    static open = new Set();
+
+    constructor(terminal) {
+        this.terminal = terminal;
+        this.matches = [];
+        this.truncated = false;
+        this.current = -1;
+        this.timer = null;
+        this.element = document.createElement("form");
+        this.element.className = "search";
+        this.element.innerHTML = `<input type="search" name="q" placeholder="Search" autocomplete="off">
+            <label title="Regular expression"><input type="checkbox" name="regex">.*</label>
+            <label title="Match case"><input type="checkbox" name="case">Aa</label>
+            <span class="count"></span>
+            <button type="button" name="previous" title="Previous match (Shift+Enter)">⬆</button>
+            <button type="button" name="next" title="Next match (Enter)">⬇</button>
+            <button type="button" name="close" title="Close (Escape)">✖</button>`;
+        this.input = this.element.elements["q"];
+        this.count = this.element.querySelector(".count");
+        this.element.addEventListener("input", () => {
+            clearTimeout(this.timer);
+            this.timer = setTimeout(() => this.Run(), 200);
+        });
+        this.element.addEventListener("submit", e => {
+            e.preventDefault();
+            this.Next(1);
+        });
+        this.element.addEventListener("keydown", e => {
+            if (e.key == "Escape") {
+                this.Close();
+            } else if (e.key == "Enter" && e.shiftKey) {
+                e.preventDefault();
+                this.Next(-1);
+            }
+        });
+        this.element.elements["previous"].addEventListener("click", () => this.Next(-1));
+        this.element.elements["next"].addEventListener("click", () => this.Next(1));
+        this.element.elements["close"].addEventListener("click", () => this.Close());
+    }
+
+    Open() {
+        if (!this.element.isConnected) {
+            this.terminal.tabElement.prepend(this.element);
+            Search.open.add(this);
+        }
+        this.input.focus();
+        this.input.select();
+    }
+
+    Close() {
+        this.element.remove();
+        Search.open.delete(this);
+        this.matches = [];
+        Search.Render();
+    }
+
+    Run() {
+        if (this.input.value == "") {
+            this.matches = [];
+            this.count.textContent = "";
+            Search.Render();
+            return;
+        }
+        const params = new URLSearchParams(new FormData(this.element));
+        fetch("/search/" + this.terminal.session_id.replace("session_", "") + "?" + params)
+            .then(response => response.text().then(text => {
+                if (!response.ok) {
+                    throw new Error(text);
+                }
+                return JSON.parse(text);
+            }))
+            .then(result => {
+                this.matches = result.matches;
+                this.truncated = result.truncated;
+                this.current = this.matches.length - 1; // the newest match is the closest to the prompt
+                this.Show();
+            })
+            .catch(err => {
+                this.matches = [];
+                this.count.textContent = err.message.trim();
+                Search.Render();
+            });
+    }
+
+    Next(direction) {
+        if (this.matches.length == 0) {
+            return;
+        }
+        this.current = (this.current + direction + this.matches.length) % this.matches.length;
+        this.Show();
+    }
+
+    Show() {
+        const total = this.truncated ? `${this.matches.length}+` : this.matches.length;
+        this.count.textContent = this.matches.length == 0 ? "No matches" : `${this.current + 1}/${total}`;
+        Search.Render();
+        const match = this.matches[this.current];
+        const row = match != null ? document.getElementById("r" + match.row) : null;
+        if (row != null) {
+            row.scrollIntoView({block: "center"});
+        }
+    }
+
+    // Range selects the characters [start, end) of the row element.
+    static Range(element, start, end) {
+        const range = document.createRange();
+        const walker = document.createTreeWalker(element, NodeFilter.SHOW_TEXT);
+        let column = 0;
+        let started = false;
+        for (let node = walker.nextNode(); node != null; node = walker.nextNode()) {
+            let offset = 0;
+            for (const c of node.data) {
+                if (column == start) {
+                    range.setStart(node, offset);
+                    started = true;
+                }
+                if (column == end) {
+                    range.setEnd(node, offset);
+                    return range;
+                }
+                column++;
+                offset += c.length;
+            }
+            if (column == end && started) {
+                range.setEnd(node, offset);
+                return range;
+            }
+        }
+        return null;
+    }
+
+    // Render highlights the matches of all open searches, rows replaced by updates need it again.
+    static Render() {
+        if (!("highlights" in CSS)) {
+            return;
+        }
+        const all = new Highlight();
+        const current = new Highlight();
+        Search.open.forEach(search => {
+            search.matches.forEach((match, i) => {
+                const row = document.getElementById("r" + match.row);
+                const range = row != null ? Search.Range(row, match.start, match.end) : null;
+                if (range != null) {
+                    (i == search.current ? current : all).add(range);
+                }
+            });
+        });
+        CSS.highlights.set("search", all);
+        CSS.highlights.set("search-current", current);
+    }
+}
+
 class Terminal {
 
     constructor(session_id, tabElement, socket) {
@@ -27,6 +341,14 @@ class Terminal {
         this.chars = 0;
 
         this.socket = socket;
+        this.search = new Search(this);
+        this.drop = new Drop(this);
+        this.binary = null;
+        this.updates = Promise.resolve();
+
+        this.tabElement.previousElementSibling.addEventListener("change", function() {
+            this.ClearBell()
+        }.bind(this));
 
         this.keyboard = new Keyboard(this.tabElement, function(c){
             this.socket.send(JSON.stringify({"type":"keyboard", "keys": c}))
@@ -36,8 +358,131 @@ class Terminal {
             if (!isScrolledToBottom) {
                 this.tabElement.scrollTop = this.tabElement.scrollHeight - this.tabElement.clientHeight
             }
+        }.bind(this),
+        function(shortcut) {
+            if (shortcut == "prompt_previous") {
+                this.JumpToPrompt(-1)
+            } else if (shortcut == "prompt_next") {
+                this.JumpToPrompt(1)
+            } else if (shortcut == "copy_output") {
+                copyLastOutput(this.session_id.replace("session_", ""))
+            } else if (shortcut == "search") {
+                this.Search()
+            }
         }.bind(this));
         this.UpdateSize();
+        if (sessionStorage.getItem("protocol_" + this.session_id) == "binary") {
+            this.SetProtocol("binary");
+        }
+    }
+
+    Search() {
+        this.search.Open();
+    }
+
+    SetProtocol(protocol) {
+        this.binary = protocol == "binary" ? new BinaryRenderer() : null;
+        sessionStorage.setItem("protocol_" + this.session_id, protocol);
+        this.socket.send(JSON.stringify({"type":"protocol", "protocol": protocol}))
+    }
+
+    ToggleProtocol() {
+        this.SetProtocol(this.binary == null ? "binary" : "html");
+    }
+
+    HandleBinary(blob) {
+        if (this.binary == null) {
+            this.Ack();
+            return;
+        }
+        // blobs are read asynchronously, keep the updates in order
+        this.updates = this.updates.then(() => blob.arrayBuffer()).then(buffer => {
+            const isScrolledToBottom = this.tabElement.scrollHeight - this.tabElement.clientHeight <= this.tabElement.scrollTop + 1
+            this.binary.Apply(document.getElementById(this.session_id), buffer);
+            Search.Render();
+            if (isScrolledToBottom) {
+                this.tabElement.scrollTop = this.tabElement.scrollHeight - this.tabElement.clientHeight
+            }
+        }).catch(err => {
+            console.error('Binary update failed: ', err);
+        }).finally(() => this.Ack());
+    }
+
+    // Ack tells the server an update was applied, it sends only a few updates ahead.
+    Ack() {
+        this.socket.send(JSON.stringify({"type":"ack"}))
+    }
+
+    HandleEvent(event) {
+        if (event.type == "clipboard") {
+            if (event.ask && !confirm(`${this.Title()} wants to copy ${event.text.length} characters to the clipboard. Allow?`)) {
+                return
+            }
+            CopyToClipboard(event.text).catch(err => {
+                console.error('Failed to write clipboard contents: ', err);
+            });
+        } else if (event.type == "bell") {
+            this.Bell(event.mode)
+        } else if (event.type == "upload") {
+            this.drop.Progress(event)
+        } else if (event.type == "clipboard_query") {
+            if (event.ask && !confirm(`${this.Title()} wants to read the clipboard. Allow?`)) {
+                return
+            }
+            navigator.clipboard.readText()
+                .then(text => {
+                    this.socket.send(JSON.stringify({"type":"clipboard", "selection": event.selection, "text": text}))
+                })
+                .catch(err => {
+                    console.error('Failed to read clipboard contents: ', err);
+                });
+        }
+    }
+
+    IsFocused() {
+        return !document.hidden && this.tabElement.parentNode.classList.contains("active") && this.tabElement.previousElementSibling.checked
+    }
+
+    Bell(mode) {
+        this.tabElement.classList.add("bell")
+        setTimeout(() => this.tabElement.classList.remove("bell"), 300)
+        if (mode == "sound") {
+            Beep()
+        } else if (mode == "notification" && !this.IsFocused() && "Notification" in window && Notification.permission == "granted") {
+            new Notification("🔔 " + this.Title())
+        }
+        if (!this.IsFocused()) {
+            const title = document.getElementById("title_" + this.session_id.replace("session_", ""))
+            if (title != null) {
+                title.classList.add("bell")
+            }
+        }
+    }
+
+    ClearBell() {
+        const title = document.getElementById("title_" + this.session_id.replace("session_", ""))
+        if (title != null) {
+            title.classList.remove("bell")
+        }
+    }
+
+    JumpToPrompt(direction) {
+        // prompt rows are marked by the server with OSC 133 shell integration
+        const element = document.getElementById(this.session_id)
+        const top = this.tabElement.getBoundingClientRect().top
+        const offsets = Array.from(element.querySelectorAll("span.prompt"), prompt => {
+            return prompt.getBoundingClientRect().top - top + this.tabElement.scrollTop
+        });
+        const current = this.tabElement.scrollTop
+        const target = direction < 0 ? offsets.findLast(offset => offset < current - 1) : offsets.find(offset => offset > current + 1)
+        if (target !== undefined) {
+            this.tabElement.scrollTop = target
+        }
+    }
+
+    Title() {
+        const title = document.getElementById("title_" + this.session_id.replace("session_", ""))
+        return title != null ? title.textContent.trim() : "The server"
     }
 
     UpdateSize() {